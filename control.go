@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"libdb.so/hserve"
+	"libdb.so/saq/internal/atomicg"
+)
+
+// ControlStatus is the status reported by the control API.
+type ControlStatus struct {
+	StartedAt time.Time `json:"startedAt"`
+	Uptime    string    `json:"uptime"`
+	// Runner is the status of the command, if any.
+	Runner RunnerStatus `json:"runner"`
+	// Monitor is the combined state of all sources.
+	Monitor string `json:"monitor"`
+	// Sources is the state of each source.
+	Sources map[string]string `json:"sources"`
+	// Browsers is the number of browsers waiting for a reload.
+	Browsers int64 `json:"browsers"`
+	// Paused is true if watching is paused.
+	Paused bool `json:"paused"`
+}
+
+// controlServer serves the control API.
+type controlServer struct {
+	runner   Runner
+	observer *Observer
+	monitor  *HTTPMonitorGroup
+	browsers *atomicg.Int
+	started  time.Time
+
+	restart func()
+	reload  func()
+	stop    func()
+}
+
+func (c *controlServer) status() ControlStatus {
+	status := ControlStatus{
+		StartedAt: c.started,
+		Uptime:    time.Since(c.started).Round(time.Second).String(),
+		Runner:    c.runner.Status(),
+		Monitor:   c.monitor.State().String(),
+		Sources:   make(map[string]string, len(c.monitor.Monitors)),
+		Browsers:  c.browsers.Get(),
+		Paused:    c.observer.Paused(),
+	}
+	for _, m := range c.monitor.Monitors {
+		status.Sources[m.Addr] = m.State().String()
+	}
+	return status
+}
+
+func (c *controlServer) handler() http.Handler {
+	logger := slog.With("component", "control")
+	actions := map[string]func(){
+		"/restart": c.restart,
+		"/reload":  c.reload,
+		"/pause":   c.observer.Pause,
+		"/resume":  c.observer.Resume,
+		"/stop":    c.stop,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			action, ok := actions[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			logger.Info("received command", "command", r.URL.Path)
+			action()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.status())
+	})
+}
+
+// serve serves the control API on the Unix socket until ctx is done.
+func (c *controlServer) serve(ctx context.Context, socket string) error {
+	logger := slog.With("component", "control", "socket", socket)
+
+	// Explicit socket paths get the same check as the default one, since
+	// whoever can get into the directory can control saq.
+	if err := ensurePrivateDir(filepath.Dir(socket)); err != nil {
+		return err
+	}
+
+	// A socket left behind by a saq that crashed is in the way, but one that
+	// is still being listened on belongs to another saq.
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			logger.Warn("control socket is used by another saq, not serving it")
+			return nil
+		}
+		os.Remove(socket)
+	}
+
+	logger.Info("control API is listening")
+	return hserve.ListenAndServe(ctx, "unix://"+socket, c.handler())
+}
+
+// defaultControlSocket returns the control socket path for saq watching the
+// given directory, so that `saq ctl` can find it from the same directory. The
+// directory of the socket is created if needed.
+func defaultControlSocket(includeDir string) (string, error) {
+	abs, err := filepath.Abs(includeDir)
+	if err != nil {
+		abs = includeDir
+	}
+	sum := sha256.Sum256([]byte(abs))
+
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "saq")
+	} else {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("saq-%d", os.Getuid()))
+	}
+
+	if err := ensurePrivateDir(dir); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, hex.EncodeToString(sum[:6])+".sock"), nil
+}
+
+// ensurePrivateDir creates the directory and its parents if they don't exist,
+// and checks that only the current user can get into it. In a shared /tmp,
+// anyone could have created it first to hijack the socket.
+func ensurePrivateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("cannot create control socket directory: %w", err)
+	}
+
+	stat, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !stat.IsDir() {
+		return fmt.Errorf("control socket directory %s is not a directory", dir)
+	}
+	if perm := stat.Mode().Perm(); perm != 0700 {
+		return fmt.Errorf("control socket directory %s has mode %#o, must be 0700", dir, perm)
+	}
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok && int(sys.Uid) != os.Getuid() {
+		return fmt.Errorf("control socket directory %s is owned by uid %d, not %d", dir, sys.Uid, os.Getuid())
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsurePrivateDir(t *testing.T) {
+	root := t.TempDir()
+
+	tests := []struct {
+		name string
+		// path is the directory relative to the test's directory, which is
+		// named after the test.
+		path    string
+		setup   func(dir string) error
+		wantErr bool
+	}{
+		{
+			name:  "missing",
+			setup: func(dir string) error { return nil },
+		},
+		{
+			name:  "missing parents",
+			path:  "a/b",
+			setup: func(dir string) error { return nil },
+		},
+		{
+			name:  "private",
+			setup: func(dir string) error { return os.Mkdir(dir, 0700) },
+		},
+		{
+			name:    "shared",
+			setup:   func(dir string) error { return os.Mkdir(dir, 0755) },
+			wantErr: true,
+		},
+		{
+			name:    "file",
+			setup:   func(dir string) error { return os.WriteFile(dir, nil, 0600) },
+			wantErr: true,
+		},
+		{
+			name: "symlink",
+			setup: func(dir string) error {
+				target := dir + ".target"
+				if err := os.Mkdir(target, 0700); err != nil {
+					return err
+				}
+				return os.Symlink(target, dir)
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := filepath.Join(root, test.name, filepath.FromSlash(test.path))
+			if err := test.setup(dir); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ensurePrivateDir(dir)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+
+			stat, err := os.Stat(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := stat.Mode().Perm(); perm != 0700 {
+				t.Errorf("mode = %#o, want 0700", perm)
+			}
+		})
+	}
+}
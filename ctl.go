@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// ctlCommands are the commands of `saq ctl` and their descriptions.
+var ctlCommands = [][2]string{
+	{"status", "show the status of saq"},
+	{"restart", "restart the command"},
+	{"reload", "reload all browsers"},
+	{"pause", "pause watching for changes"},
+	{"resume", "resume watching for changes"},
+	{"stop", "stop saq"},
+}
+
+// runCtl runs `saq ctl` and returns the exit code.
+func runCtl(args []string) int {
+	flags := pflag.NewFlagSet("ctl", pflag.ContinueOnError)
+	socket := flags.String("control", "auto", "socket path of the control API, auto to derive from --include")
+	include := flags.StringP("include", "i", includeDir, "include directory of the saq to control")
+	asJSON := flags.Bool("json", false, "print the status as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ctl [flags...] command\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Commands:")
+		for _, cmd := range ctlCommands {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd[0], cmd[1])
+		}
+		fmt.Fprintln(os.Stderr, "Flags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	command := flags.Arg(0)
+	known := false
+	for _, cmd := range ctlCommands {
+		known = known || cmd[0] == command
+	}
+	if !known {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", command)
+		flags.Usage()
+		return 2
+	}
+
+	if *socket == "auto" {
+		var err error
+		*socket, err = defaultControlSocket(*include)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "saq ctl:", err)
+			return 1
+		}
+	}
+
+	status, err := ctlRequest(*socket, command)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "saq ctl:", err)
+		return 1
+	}
+
+	if *asJSON {
+		os.Stdout.Write(status)
+		return 0
+	}
+
+	if command == "status" {
+		var s ControlStatus
+		if err := json.Unmarshal(status, &s); err != nil {
+			fmt.Fprintln(os.Stderr, "saq ctl: invalid status:", err)
+			return 1
+		}
+		printStatus(os.Stdout, s)
+	}
+
+	return 0
+}
+
+func ctlRequest(socket, command string) ([]byte, error) {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	method := http.MethodPost
+	if command == "status" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequest(method, "http://saq/"+command, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach saq at %s: %w", socket, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+func printStatus(w io.Writer, s ControlStatus) {
+	fmt.Fprintf(w, "uptime:    %s\n", s.Uptime)
+
+	switch {
+	case s.Runner.Starts == 0:
+		fmt.Fprintln(w, "runner:    no command")
+	case s.Runner.PID != 0:
+		fmt.Fprintf(w, "runner:    running as pid %d for %s, started %d times\n",
+			s.Runner.PID, time.Since(s.Runner.StartedAt).Round(time.Second), s.Runner.Starts)
+	default:
+		fmt.Fprintf(w, "runner:    not running, started %d times\n", s.Runner.Starts)
+	}
+	if s.Runner.ExitCode != nil {
+		fmt.Fprintf(w, "last exit: %d\n", *s.Runner.ExitCode)
+	}
+
+	fmt.Fprintf(w, "monitor:   %s\n", s.Monitor)
+
+	sources := make([]string, 0, len(s.Sources))
+	for source := range s.Sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		fmt.Fprintf(w, "  %s: %s\n", source, s.Sources[source])
+	}
+
+	fmt.Fprintf(w, "browsers:  %d\n", s.Browsers)
+
+	if s.Paused {
+		fmt.Fprintln(w, "watching:  paused")
+	} else {
+		fmt.Fprintln(w, "watching:  yes")
+	}
+}
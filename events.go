@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"libdb.so/saq/internal/atomicg"
+)
+
+// eventQueueSize is the number of events that are queued while the reader of
+// the event stream is falling behind. Events past that are dropped.
+const eventQueueSize = 256
+
+// eventStream writes saq's lifecycle events as newline-delimited JSON, one
+// object per line with its time, type and the fields of the event:
+//
+//	{"time":"...","type":"runner.exit","pid":1234,"code":1}
+//
+// A nil eventStream drops all events.
+type eventStream struct {
+	path    string
+	lines   chan []byte
+	dropped atomicg.Int
+	logger  *slog.Logger
+}
+
+// newEventStream creates an event stream that writes to the file at path, or
+// stdout if path is "-". The file may be a FIFO, in which case it is reopened
+// whenever the reader goes away.
+func newEventStream(path string) *eventStream {
+	return &eventStream{
+		path:   path,
+		lines:  make(chan []byte, eventQueueSize),
+		logger: slog.With("component", "events", "path", path),
+	}
+}
+
+// Emit emits an event of the given type. The fields are key-value pairs like
+// slog's. Emit never blocks.
+func (s *eventStream) Emit(typ string, fields ...any) {
+	if s == nil {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJSON(&b, time.Now())
+	b.WriteString(`,"type":`)
+	writeJSON(&b, typ)
+	for i := 0; i+1 < len(fields); i += 2 {
+		b.WriteByte(',')
+		writeJSON(&b, fields[i])
+		b.WriteByte(':')
+		writeJSON(&b, fields[i+1])
+	}
+	b.WriteString("}\n")
+
+	select {
+	case s.lines <- b.Bytes():
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func writeJSON(b *bytes.Buffer, v any) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		j, _ = json.Marshal(err.Error())
+	}
+	b.Write(j)
+}
+
+// Start writes the events until ctx is done.
+func (s *eventStream) Start(ctx context.Context) error {
+	for {
+		w, err := s.open(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = s.write(ctx, w)
+		if s.path != "-" {
+			w.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// open opens the destination. Opening a FIFO blocks until there's a reader, so
+// it's done in the background to be able to give up once ctx is done.
+func (s *eventStream) open(ctx context.Context) (io.WriteCloser, error) {
+	if s.path == "-" {
+		return os.Stdout, nil
+	}
+
+	type result struct {
+		f   *os.File
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		ch <- result{f, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		return r.f, r.err
+	}
+}
+
+// write writes events to w until ctx is done, in which case ctx's error is
+// returned, or until writing fails, in which case nil is returned so that the
+// destination is reopened.
+func (s *eventStream) write(ctx context.Context, w io.Writer) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case line := <-s.lines:
+			if dropped := s.dropped.Swap(0); dropped > 0 {
+				s.logger.Warn("events were dropped because the reader is too slow", "count", dropped)
+			}
+			if _, err := w.Write(line); err != nil {
+				s.logger.Warn("cannot write event, reopening", "err", err)
+				return nil
+			}
+		}
+	}
+}
+
+// Follow emits events for file changes, the command starting and exiting, and
+// the sources changing state until ctx is done. The current state of the
+// command and the sources is emitted first. All of these are published with a
+// buffer, so that events aren't lost while Emit is busy.
+func (s *eventStream) Follow(ctx context.Context, observer *Observer, runner Runner, monitor *HTTPMonitorGroup) error {
+	observeCh := observer.Subscribe()
+	defer observer.Unsubscribe(observeCh)
+
+	processCh := runner.Processes().Subscribe()
+	defer runner.Processes().Unsubscribe(processCh)
+
+	monitorCh := monitor.Subscribe()
+	defer monitor.Unsubscribe(monitorCh)
+
+	// The command has likely started before we subscribed, so catch up. Its
+	// start may also be waiting in processCh, so don't report it twice.
+	started := runner.Status().PID
+	if started != 0 {
+		s.Emit("runner.start", "pid", started)
+	}
+	state := monitor.State()
+	s.Emit("monitor.state", "state", state.String(), "alive", state == HTTPStateAlive)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case files := <-observeCh:
+			s.Emit("fs.change", "paths", files)
+		case proc := <-processCh:
+			switch {
+			case proc.Exited:
+				s.Emit("runner.exit", "pid", proc.PID, "code", proc.ExitCode)
+			case proc.PID != started:
+				s.Emit("runner.start", "pid", proc.PID)
+			}
+		case state := <-monitorCh:
+			s.Emit("monitor.state", "state", state.String(), "alive", state == HTTPStateAlive)
+		}
+	}
+}
@@ -1,18 +1,25 @@
 module libdb.so/saq
 
-go 1.20
+go 1.21
 
 require (
-	github.com/diamondburned/ghproxy v0.0.0-20201025235419-194be0dfdd7b
+	github.com/alecthomas/chroma/v2 v2.2.0
+	github.com/andybalholm/brotli v1.1.0
 	github.com/illarion/gonotify/v2 v2.0.0
+	github.com/klauspost/compress v1.17.9
 	github.com/pkg/browser v0.0.0-20210911075715-681adbf594b8
 	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06
 	github.com/spf13/pflag v1.0.5
-	golang.org/x/sync v0.2.0
+	github.com/yuin/goldmark v1.7.8
+	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
+	golang.org/x/net v0.33.0
+	golang.org/x/sync v0.10.0
+	golang.org/x/sys v0.28.0
+	golang.org/x/term v0.27.0
 	libdb.so/hserve v0.0.0-20230404043009-95e112a6e0a5
 )
 
 require (
-	github.com/pkg/errors v0.9.1 // indirect
-	golang.org/x/sys v0.4.0 // indirect
+	github.com/dlclark/regexp2 v1.7.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
 	"time"
+
+	"golang.org/x/sync/errgroup"
+	"libdb.so/saq/internal/atomicg"
 )
 
 type HTTPState int
@@ -35,23 +39,32 @@ type httpMonitorRefresh struct {
 // HTTPMonitor is a HTTP monitor.
 type HTTPMonitor struct {
 	Subscriber[HTTPState]
-	Addr string
+	Addr   string
+	Client *http.Client
 
-	pubsub    *Pubsub[HTTPState]
-	refresh   chan httpMonitorRefresh
-	lastState HTTPState
+	pubsub  *Pubsub[HTTPState]
+	refresh chan httpMonitorRefresh
+	state   atomicg.Int
+	logger  *slog.Logger
 }
 
-// NewHTTPMonitor creates a new HTTP monitor.
-func NewHTTPMonitor(addr string) *HTTPMonitor {
+// NewHTTPMonitor creates a new HTTP monitor. If client is nil, then
+// http.DefaultClient is used.
+func NewHTTPMonitor(addr string, client *http.Client) *HTTPMonitor {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
 	pubsub := NewPubsub[HTTPState]()
 	refresh := make(chan httpMonitorRefresh, 1)
 	refresh <- httpMonitorRefresh{until: HTTPStateAlive}
 	return &HTTPMonitor{
 		Subscriber: pubsub,
 		Addr:       addr,
+		Client:     client,
 		pubsub:     pubsub,
 		refresh:    refresh,
+		logger:     slog.With("component", "monitor", "addr", addr),
 	}
 }
 
@@ -66,7 +79,7 @@ func (m *HTTPMonitor) Refresh() {
 // RefreshUntilState refreshes the monitor until the state is the given state.
 // It blocks until the refresh is received.
 func (m *HTTPMonitor) RefreshUntilState(ctx context.Context, until HTTPState) error {
-	log.Println("delivering refresh until", until, "to http monitor")
+	m.logger.Debug("delivering refresh", "until", until.String())
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -81,7 +94,7 @@ func (m *HTTPMonitor) Start(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case refresh := <-m.refresh:
-			log.Println("http monitor received refresh until", refresh.until)
+			m.logger.Debug("received refresh", "until", refresh.until.String())
 			m.publish(HTTPStateUnknown)
 			if err := m.pingHTTPUntilState(ctx, m.Addr, refresh.until); err != nil {
 				return err
@@ -106,24 +119,22 @@ func (m *HTTPMonitor) pingHTTPUntilState(ctx context.Context, addr string, until
 
 		var state HTTPState
 
-		r, err := http.Head(addr)
+		r, err := m.Client.Head(addr)
 		if err == nil {
 			r.Body.Close()
 
-			log.Println("source server is alive")
 			state = HTTPStateAlive
 		} else {
-			log.Println("cannot ping source server:", err)
+			m.logger.Debug("cannot ping source server", "err", err)
 			state = HTTPStateDead
 		}
 
 		m.publish(state)
 		if state == until {
-			log.Println("source server is now actually", state)
+			m.logger.Info("source server reached state", "state", state.String())
 			return nil
 		}
 
-		log.Println("retrying in", retryDelay)
 		timer.Reset(retryDelay)
 		select {
 		case <-ctx.Done():
@@ -134,9 +145,114 @@ func (m *HTTPMonitor) pingHTTPUntilState(ctx context.Context, addr string, until
 	}
 }
 
+// State returns the last known state of the server.
+func (m *HTTPMonitor) State() HTTPState {
+	return HTTPState(m.state.Get())
+}
+
 func (m *HTTPMonitor) publish(newState HTTPState) {
-	if m.lastState != newState {
-		m.lastState = newState
+	if HTTPState(m.state.Swap(int64(newState))) != newState {
 		m.pubsub.Publish(newState)
 	}
 }
+
+// HTTPMonitorGroup combines multiple HTTP monitors into one. The group is only
+// alive once all of its monitors are alive, and it is dead if any of them is
+// dead.
+type HTTPMonitorGroup struct {
+	Subscriber[HTTPState]
+	Monitors []*HTTPMonitor
+
+	pubsub *Pubsub[HTTPState]
+	state  atomicg.Int
+}
+
+// httpGroupBuffer is the number of state changes that a subscriber of an
+// HTTPMonitorGroup can fall behind by, so that the event stream sees every
+// change.
+const httpGroupBuffer = 8
+
+// NewHTTPMonitorGroup creates a new HTTP monitor group.
+func NewHTTPMonitorGroup(monitors ...*HTTPMonitor) *HTTPMonitorGroup {
+	pubsub := NewBufferedPubsub[HTTPState](httpGroupBuffer)
+	return &HTTPMonitorGroup{
+		Subscriber: pubsub,
+		Monitors:   monitors,
+		pubsub:     pubsub,
+	}
+}
+
+// RefreshUntilState refreshes all monitors until they're in the given state.
+func (g *HTTPMonitorGroup) RefreshUntilState(ctx context.Context, until HTTPState) error {
+	for _, m := range g.Monitors {
+		if err := m.RefreshUntilState(ctx, until); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start starts all monitors in the group until the context is canceled.
+func (g *HTTPMonitorGroup) Start(ctx context.Context) error {
+	var mu sync.Mutex
+
+	errg, ctx := errgroup.WithContext(ctx)
+	for _, m := range g.Monitors {
+		m := m
+
+		ch := m.Subscribe()
+		errg.Go(func() error {
+			defer m.Unsubscribe(ch)
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-ch:
+					// Publishing is lossy, so what was received may already
+					// be stale. Ask every monitor instead.
+					mu.Lock()
+					g.publish(g.combinedState())
+					mu.Unlock()
+				}
+			}
+		})
+
+		errg.Go(func() error {
+			return m.Start(ctx)
+		})
+	}
+
+	return errg.Wait()
+}
+
+func (g *HTTPMonitorGroup) combinedState() HTTPState {
+	states := make([]HTTPState, len(g.Monitors))
+	for i, m := range g.Monitors {
+		states[i] = m.State()
+	}
+	return combineHTTPStates(states)
+}
+
+func combineHTTPStates(states []HTTPState) HTTPState {
+	combined := HTTPStateAlive
+	for _, state := range states {
+		switch state {
+		case HTTPStateDead:
+			return HTTPStateDead
+		case HTTPStateUnknown:
+			combined = HTTPStateUnknown
+		}
+	}
+	return combined
+}
+
+// State returns the combined state of all monitors.
+func (g *HTTPMonitorGroup) State() HTTPState {
+	return HTTPState(g.state.Get())
+}
+
+func (g *HTTPMonitorGroup) publish(newState HTTPState) {
+	if HTTPState(g.state.Swap(int64(newState))) != newState {
+		g.pubsub.Publish(newState)
+	}
+}
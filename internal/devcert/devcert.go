@@ -0,0 +1,269 @@
+// Package devcert generates and persists a local certificate authority and
+// leaf certificates signed by it for serving HTTPS during development.
+package devcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 365 * 24 * time.Hour
+	// leafRenewal is how long before expiry the leaf certificate is renewed.
+	leafRenewal = 30 * 24 * time.Hour
+)
+
+// Authority is a local certificate authority.
+type Authority struct {
+	// CertFile is the path to the PEM-encoded CA certificate. This is the file
+	// that should be trusted by the system.
+	CertFile string
+	// Created is true if the CA was just created.
+	Created bool
+
+	cert *x509.Certificate
+	key  crypto.Signer
+	dir  string
+}
+
+// LoadAuthority loads the CA from the given directory, creating one if there
+// isn't any.
+func LoadAuthority(dir string) (*Authority, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create cert directory: %w", err)
+	}
+
+	ca := &Authority{
+		CertFile: filepath.Join(dir, "ca.pem"),
+		dir:      dir,
+	}
+	keyFile := filepath.Join(dir, "ca-key.pem")
+
+	cert, key, err := loadPair(ca.CertFile, keyFile)
+	if err == nil && time.Now().Before(cert.NotAfter) {
+		ca.cert = cert
+		ca.key = key
+		return ca, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cannot load CA: %w", err)
+	}
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate CA key: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject: pkix.Name{
+			Organization: []string{"saq development CA"},
+			CommonName:   "saq development CA on " + hostname,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create CA certificate: %w", err)
+	}
+
+	if err := savePair(ca.CertFile, keyFile, der, key); err != nil {
+		return nil, err
+	}
+
+	ca.cert, _ = x509.ParseCertificate(der)
+	ca.key = key
+	ca.Created = true
+
+	return ca, nil
+}
+
+// LeafCertificate returns a certificate signed by the CA that is valid for all
+// the given hosts, which may be hostnames or IP addresses. The certificate is
+// persisted alongside the CA and only regenerated when it's about to expire
+// or when it doesn't cover all hosts.
+func (ca *Authority) LeafCertificate(hosts []string) (tls.Certificate, error) {
+	certFile := filepath.Join(ca.dir, "cert.pem")
+	keyFile := filepath.Join(ca.dir, "cert-key.pem")
+
+	cert, key, err := loadPair(certFile, keyFile)
+	if err == nil && ca.leafIsValid(cert, hosts) {
+		return tlsCertificate(cert, key, ca.cert), nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return tls.Certificate{}, fmt.Errorf("cannot load certificate: %w", err)
+	}
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot generate key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject: pkix.Name{
+			Organization: []string{"saq development certificate"},
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(leafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("cannot create certificate: %w", err)
+	}
+
+	if err := savePair(certFile, keyFile, der, key); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	cert, _ = x509.ParseCertificate(der)
+	return tlsCertificate(cert, key, ca.cert), nil
+}
+
+func (ca *Authority) leafIsValid(cert *x509.Certificate, hosts []string) bool {
+	if time.Now().Add(leafRenewal).After(cert.NotAfter) {
+		return false
+	}
+
+	if cert.CheckSignatureFrom(ca.cert) != nil {
+		return false
+	}
+
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func tlsCertificate(cert *x509.Certificate, key crypto.Signer, ca *x509.Certificate) tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{cert.Raw, ca.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+}
+
+func loadPair(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("%s: no PEM data", certFile)
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", certFile, err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("%s: no PEM data", keyFile)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: unsupported key type %T", keyFile, key)
+	}
+
+	return cert, signer, nil
+}
+
+func savePair(certFile, keyFile string, der []byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("cannot marshal key: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("cannot save key: %w", err)
+	}
+
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("cannot save certificate: %w", err)
+	}
+
+	return nil
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic("cannot generate serial number: " + err.Error())
+	}
+	return serial
+}
+
+// LANAddrs returns the IP addresses of this machine that are reachable from
+// the local network.
+func LANAddrs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip := ipNet.IP; ip.IsPrivate() && !ip.IsLoopback() {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
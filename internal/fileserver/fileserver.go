@@ -0,0 +1,301 @@
+// Package fileserver is a static file server for development.
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Mount serves a directory at a URL path prefix.
+type Mount struct {
+	Prefix string
+	Dir    string
+}
+
+// ParseMount parses a mount in the form of "/prefix=./dir".
+func ParseMount(s string) (Mount, error) {
+	prefix, dir, ok := strings.Cut(s, "=")
+	if !ok {
+		return Mount{}, fmt.Errorf("mount %q is missing =", s)
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return Mount{}, fmt.Errorf("mount prefix %q must start with /", prefix)
+	}
+	if dir == "" {
+		return Mount{}, fmt.Errorf("mount %q has no directory", s)
+	}
+	return Mount{Prefix: path.Clean(prefix), Dir: dir}, nil
+}
+
+// rel returns the path relative to the mount, or false if the path isn't in
+// it.
+func (m Mount) rel(upath string) (string, bool) {
+	if m.Prefix == "/" {
+		return upath, true
+	}
+	if upath == m.Prefix {
+		return "/", true
+	}
+	if rest := strings.TrimPrefix(upath, m.Prefix); len(rest) < len(upath) && strings.HasPrefix(rest, "/") {
+		return rest, true
+	}
+	return "", false
+}
+
+// Opts are the options for a Server.
+type Opts struct {
+	// Index are the files that are served for a directory, in order of
+	// preference. Defaults to index.html.
+	Index []string
+	// SPA serves the root index file for paths that don't exist, so that
+	// client-side routing works on reload.
+	SPA bool
+	// CleanURLs serves /about.html for /about.
+	CleanURLs bool
+	// NotFound is the URL path of the file that is served for paths that
+	// don't exist. It's looked up through the mounts like any other path.
+	NotFound string
+	// NoListing disables directory listings.
+	NoListing bool
+	// Markdown renders Markdown files as HTML, unless ?raw is given. A README
+	// is also rendered below directory listings.
+	Markdown bool
+}
+
+// Server serves files from mounted directories. Nothing is cached, since the
+// files are expected to change.
+//
+// Mounts overlay each other: a path is looked up in every mount that contains
+// it, the most specific prefix first and then in the given order, and the
+// first mount that has it wins.
+type Server struct {
+	mounts []Mount
+	opts   Opts
+	logger *slog.Logger
+}
+
+// NewServer creates a new file server for the given mounts.
+func NewServer(mounts []Mount, opts Opts) *Server {
+	if len(opts.Index) == 0 {
+		opts.Index = []string{"index.html"}
+	}
+
+	mounts = append([]Mount(nil), mounts...)
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].Prefix) > len(mounts[j].Prefix)
+	})
+
+	return &Server{
+		mounts: mounts,
+		opts:   opts,
+		logger: slog.With("component", "fileserver"),
+	}
+}
+
+// mimeTypes overrides the system MIME types, which are often missing or wrong
+// for these.
+var mimeTypes = map[string]string{
+	".html":        "text/html; charset=utf-8",
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".cjs":         "text/javascript; charset=utf-8",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".wasm":        "application/wasm",
+	".svg":         "image/svg+xml",
+	".avif":        "image/avif",
+	".webp":        "image/webp",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".txt":         "text/plain; charset=utf-8",
+	".md":          "text/markdown; charset=utf-8",
+}
+
+// ContentType returns the content type of the file from its extension, or an
+// empty string if it's unknown.
+func ContentType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if t, ok := mimeTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	upath := path.Clean("/" + r.URL.Path)
+
+	name, stat, err := s.lookup(upath)
+	switch {
+	case err == nil && stat.IsDir():
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			redirectDir(w, r)
+			return
+		}
+		if index := s.findIndex(upath); index != "" {
+			s.serveFile(w, r, index, http.StatusOK)
+			return
+		}
+		if !s.opts.NoListing {
+			s.serveListing(w, r, upath)
+			return
+		}
+
+	case err == nil:
+		if s.opts.Markdown && isMarkdown(name) && !r.URL.Query().Has("raw") {
+			s.serveMarkdown(w, r, name, upath)
+			return
+		}
+		s.serveFile(w, r, name, http.StatusOK)
+		return
+
+	case errors.Is(err, fs.ErrNotExist):
+		if s.opts.CleanURLs && !strings.HasSuffix(upath, "/") {
+			if html := s.lookupFile(upath + ".html"); html != "" {
+				s.serveFile(w, r, html, http.StatusOK)
+				return
+			}
+			if md := s.lookupFile(upath + ".md"); s.opts.Markdown && md != "" {
+				s.serveMarkdown(w, r, md, upath+".md")
+				return
+			}
+		}
+		// Paths that look like files are probably assets that are actually
+		// missing, so they still get a 404.
+		if s.opts.SPA && path.Ext(upath) == "" {
+			if index := s.findIndex("/"); index != "" {
+				s.serveFile(w, r, index, http.StatusOK)
+				return
+			}
+		}
+
+	default:
+		s.logger.Error("cannot stat file", "err", err)
+		http.Error(w, "cannot stat file", http.StatusInternalServerError)
+		return
+	}
+
+	s.serveNotFound(w, r)
+}
+
+// lookup finds the file of the cleaned URL path in the mounts.
+func (s *Server) lookup(upath string) (string, fs.FileInfo, error) {
+	err := fs.ErrNotExist
+	for _, m := range s.mounts {
+		rel, ok := m.rel(upath)
+		if !ok {
+			continue
+		}
+
+		name := filepath.Join(m.Dir, filepath.FromSlash(rel))
+		stat, statErr := os.Stat(name)
+		if statErr == nil {
+			return name, stat, nil
+		}
+		// Keep looking in the other mounts, but don't hide real errors.
+		if errors.Is(statErr, fs.ErrPermission) {
+			err = statErr
+		}
+	}
+	return "", nil, err
+}
+
+// lookupFile is like lookup, but it only returns regular files.
+func (s *Server) lookupFile(upath string) string {
+	name, stat, err := s.lookup(upath)
+	if err != nil || !stat.Mode().IsRegular() {
+		return ""
+	}
+	return name
+}
+
+func (s *Server) findIndex(upath string) string {
+	for _, index := range s.opts.Index {
+		if name := s.lookupFile(path.Join(upath, index)); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func (s *Server) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	if s.opts.NotFound != "" {
+		if name := s.lookupFile(path.Clean("/" + s.opts.NotFound)); name != "" {
+			s.serveFile(w, r, name, http.StatusNotFound)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// serveFile serves the file. If status isn't 200, then the file is served
+// with that status and without range or conditional request support.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, name string, status int) {
+	f, err := os.Open(name)
+	if err != nil {
+		s.logger.Error("cannot open file", "err", err)
+		http.Error(w, "cannot open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		s.logger.Error("cannot stat file", "err", err)
+		http.Error(w, "cannot stat file", http.StatusInternalServerError)
+		return
+	}
+
+	if t := ContentType(name); t != "" {
+		w.Header().Set("Content-Type", t)
+	}
+
+	if status == http.StatusOK {
+		http.ServeContent(w, r, name, stat.ModTime(), f)
+		return
+	}
+
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		io.Copy(w, f)
+	}
+}
+
+// redirectDir redirects to the path with a trailing slash, keeping the query.
+func redirectDir(w http.ResponseWriter, r *http.Request) {
+	u := *r.URL
+	u.Path += "/"
+	u.RawPath = ""
+	http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+}
+
+func isMarkdown(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	default:
+		return false
+	}
+}
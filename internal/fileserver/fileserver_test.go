@@ -0,0 +1,347 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMount(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Mount
+		wantErr bool
+	}{
+		{in: "/=./public", want: Mount{Prefix: "/", Dir: "./public"}},
+		{in: "/assets=./dist", want: Mount{Prefix: "/assets", Dir: "./dist"}},
+		{in: "/assets/=./dist", want: Mount{Prefix: "/assets", Dir: "./dist"}},
+		{in: "/a//b/../c=/srv/c", want: Mount{Prefix: "/a/c", Dir: "/srv/c"}},
+		{in: "/docs=./a=b", want: Mount{Prefix: "/docs", Dir: "./a=b"}},
+		{in: "./public", wantErr: true},
+		{in: "assets=./dist", wantErr: true},
+		{in: "/assets=", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			mount, err := ParseMount(test.in)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", mount)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if mount != test.want {
+				t.Errorf("mount = %+v, want %+v", mount, test.want)
+			}
+		})
+	}
+}
+
+func TestMountRel(t *testing.T) {
+	tests := []struct {
+		prefix string
+		path   string
+		want   string
+		ok     bool
+	}{
+		{"/", "/index.html", "/index.html", true},
+		{"/assets", "/assets", "/", true},
+		{"/assets", "/assets/app.js", "/app.js", true},
+		{"/assets", "/assetsfoo/app.js", "", false},
+		{"/assets", "/other", "", false},
+	}
+
+	for _, test := range tests {
+		got, ok := Mount{Prefix: test.prefix}.rel(test.path)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%q.rel(%q) = %q, %v, want %q, %v", test.prefix, test.path, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// writeFiles writes the files, which are named relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestServerServeHTTP(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"index.html":         "index",
+		"about.html":         "about",
+		"404.html":           "not found page",
+		"app.js":             "app",
+		"docs/index.htm":     "docs index.htm",
+		"docs/default.html":  "docs default",
+		"blog/default.html":  "blog default",
+		"empty/.gitkeep":     "",
+		"assets/style.css":   "style",
+		"assets/about/x.txt": "x",
+	})
+
+	tests := []struct {
+		name     string
+		opts     Opts
+		method   string
+		target   string
+		status   int
+		body     string
+		location string
+		allow    string
+	}{
+		{
+			name:   "file",
+			target: "/app.js",
+			status: 200,
+			body:   "app",
+		},
+		{
+			name:   "root index",
+			target: "/",
+			status: 200,
+			body:   "index",
+		},
+		{
+			name:   "missing",
+			target: "/nope",
+			status: 404,
+			body:   "404 page not found\n",
+		},
+		{
+			name:   "index order",
+			opts:   Opts{Index: []string{"index.htm", "default.html"}},
+			target: "/docs/",
+			status: 200,
+			body:   "docs index.htm",
+		},
+		{
+			name:   "later index",
+			opts:   Opts{Index: []string{"index.htm", "default.html"}},
+			target: "/blog/",
+			status: 200,
+			body:   "blog default",
+		},
+		{
+			name:     "trailing slash redirect",
+			target:   "/docs?a=1&b=2",
+			status:   301,
+			location: "/docs/?a=1&b=2",
+		},
+		{
+			name:   "no listing",
+			opts:   Opts{NoListing: true},
+			target: "/empty/",
+			status: 404,
+			body:   "404 page not found\n",
+		},
+		{
+			name:   "clean url",
+			opts:   Opts{CleanURLs: true},
+			target: "/about",
+			status: 200,
+			body:   "about",
+		},
+		{
+			name:     "clean url prefers directory",
+			opts:     Opts{CleanURLs: true, SPA: true},
+			target:   "/assets/about",
+			status:   301,
+			location: "/assets/about/",
+		},
+		{
+			name:   "no clean urls",
+			target: "/about",
+			status: 404,
+			body:   "404 page not found\n",
+		},
+		{
+			name:   "spa fallback",
+			opts:   Opts{SPA: true},
+			target: "/users/1",
+			status: 200,
+			body:   "index",
+		},
+		{
+			name:   "spa missing asset",
+			opts:   Opts{SPA: true},
+			target: "/assets/missing.css",
+			status: 404,
+			body:   "404 page not found\n",
+		},
+		{
+			name:   "not found page",
+			opts:   Opts{NotFound: "404.html"},
+			target: "/nope",
+			status: 404,
+			body:   "not found page",
+		},
+		{
+			name:   "not found page behind spa",
+			opts:   Opts{SPA: true, NotFound: "/404.html"},
+			target: "/nope.png",
+			status: 404,
+			body:   "not found page",
+		},
+		{
+			name:   "missing not found page",
+			opts:   Opts{NotFound: "/missing.html"},
+			target: "/nope",
+			status: 404,
+			body:   "404 page not found\n",
+		},
+		{
+			name:   "head",
+			method: "HEAD",
+			target: "/app.js",
+			status: 200,
+		},
+		{
+			name:   "head not found page",
+			opts:   Opts{NotFound: "/404.html"},
+			method: "HEAD",
+			target: "/nope",
+			status: 404,
+		},
+		{
+			name:   "post",
+			method: "POST",
+			target: "/app.js",
+			status: 405,
+			body:   "method not allowed\n",
+			allow:  "GET, HEAD",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = "GET"
+			}
+
+			s := NewServer([]Mount{{Prefix: "/", Dir: dir}}, test.opts)
+			w := serve(s, method, test.target)
+
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+			if test.status != 301 {
+				if got := w.Body.String(); got != test.body {
+					t.Errorf("body = %q, want %q", got, test.body)
+				}
+			}
+			if got := w.Header().Get("Location"); got != test.location {
+				t.Errorf("Location = %q, want %q", got, test.location)
+			}
+			if got := w.Header().Get("Allow"); got != test.allow {
+				t.Errorf("Allow = %q, want %q", got, test.allow)
+			}
+		})
+	}
+}
+
+func TestServerLookup(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a/both.txt":        "a",
+		"a/assets/both.txt": "a",
+		"a/assets/a.txt":    "a",
+		"b/both.txt":        "b",
+		"c/both.txt":        "c",
+		"c/c.txt":           "c",
+	})
+
+	s := NewServer([]Mount{
+		{Prefix: "/", Dir: filepath.Join(root, "a")},
+		{Prefix: "/assets", Dir: filepath.Join(root, "b")},
+		{Prefix: "/", Dir: filepath.Join(root, "c")},
+	}, Opts{})
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		// The most specific prefix wins, even though it's given later.
+		{"/assets/both.txt", 200, "b"},
+		// Other mounts are tried if the most specific one doesn't have it.
+		{"/assets/a.txt", 200, "a"},
+		// Mounts with the same prefix are tried in the given order.
+		{"/both.txt", 200, "a"},
+		{"/c.txt", 200, "c"},
+		{"/missing.txt", 404, "404 page not found\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			w := serve(s, "GET", test.target)
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+			if got := w.Body.String(); got != test.body {
+				t.Errorf("body = %q, want %q", got, test.body)
+			}
+		})
+	}
+}
+
+func TestServerLookupPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions don't apply to root")
+	}
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"locked/secret.txt": "secret",
+		"open/open.txt":     "open",
+	})
+
+	locked := filepath.Join(root, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(locked, 0755) })
+
+	s := NewServer([]Mount{
+		{Prefix: "/", Dir: locked},
+		{Prefix: "/", Dir: filepath.Join(root, "open")},
+	}, Opts{})
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		// The locked mount is passed over for files that other mounts have.
+		{"/open.txt", 200},
+		// Files that no mount has don't pretend to be missing, since the
+		// locked mount might have them.
+		{"/secret.txt", 500},
+	}
+
+	for _, test := range tests {
+		if w := serve(s, "GET", test.target); w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.target, w.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type listingEntry struct {
+	Name    string
+	URL     string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+type listing struct {
+	Path    string
+	Parent  bool
+	Entries []listingEntry
+	Sort    string
+	Desc    bool
+	Readme  template.HTML
+	CSS     template.CSS
+}
+
+// SortURL returns the URL that sorts by the given column, toggling the order
+// if it's already sorted by it.
+func (l listing) SortURL(by string) string {
+	q := url.Values{"sort": {by}}
+	if l.Sort == by && !l.Desc {
+		q.Set("order", "desc")
+	}
+	return "?" + q.Encode()
+}
+
+func (s *Server) serveListing(w http.ResponseWriter, r *http.Request, upath string) {
+	entries, err := s.readDir(upath)
+	if err != nil {
+		s.logger.Error("cannot read directory", "err", err)
+		http.Error(w, "cannot read directory", http.StatusInternalServerError)
+		return
+	}
+
+	l := listing{
+		Path:    upath,
+		Parent:  upath != "/",
+		Entries: entries,
+		Sort:    r.URL.Query().Get("sort"),
+		Desc:    r.URL.Query().Get("order") == "desc",
+	}
+
+	sortEntries(l.Entries, l.Sort, l.Desc)
+
+	if s.opts.Markdown {
+		l.Readme = s.renderReadme(upath)
+		l.CSS = highlightCSS
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := listingPage.Execute(w, l); err != nil {
+		s.logger.Error("cannot render listing", "err", err)
+	}
+}
+
+// readDir lists the directory at the URL path, merging all mounts that have
+// it. Mounts below the directory show up as directories.
+func (s *Server) readDir(upath string) ([]listingEntry, error) {
+	var entries []listingEntry
+	seen := make(map[string]bool)
+
+	add := func(name string, isDir bool, info fs.FileInfo) {
+		if isDir {
+			name += "/"
+		}
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+
+		entries = append(entries, listingEntry{
+			Name:    name,
+			URL:     (&url.URL{Path: name}).String(),
+			IsDir:   isDir,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	var found bool
+	for _, m := range s.mounts {
+		rel, ok := m.rel(upath)
+		if !ok {
+			continue
+		}
+
+		des, err := os.ReadDir(filepath.Join(m.Dir, filepath.FromSlash(rel)))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+
+		for _, de := range des {
+			if info, err := de.Info(); err == nil {
+				add(de.Name(), de.IsDir(), info)
+			}
+		}
+	}
+
+	for _, m := range s.mounts {
+		if m.Prefix == "/" || path.Dir(m.Prefix) != upath {
+			continue
+		}
+		if info, err := os.Stat(m.Dir); err == nil && info.IsDir() {
+			found = true
+			add(path.Base(m.Prefix), true, info)
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s: %w", upath, fs.ErrNotExist)
+	}
+
+	return entries, nil
+}
+
+// sortEntries sorts the entries by name, size or time. Directories always come
+// first.
+func sortEntries(entries []listingEntry, by string, desc bool) {
+	less := func(a, b listingEntry) bool {
+		switch by {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "time":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+var listingFuncs = template.FuncMap{
+	"size": func(n int64) string {
+		const unit = 1024
+		if n < unit {
+			return fmt.Sprintf("%d B", n)
+		}
+		div, exp := int64(unit), 0
+		for m := n / unit; m >= unit; m /= unit {
+			div *= unit
+			exp++
+		}
+		return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+	},
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+}
+
+var listingPage = template.Must(template.New("listing").Funcs(listingFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Index of {{ .Path }}</title>
+	<style>
+		body { font-family: system-ui, sans-serif; margin: 1em; }
+		table { border-collapse: collapse; width: 100%; }
+		th, td { text-align: left; padding: 0.2em 0.5em; border-bottom: 1px solid #8884; }
+		td.size { white-space: nowrap; }
+		@media (prefers-color-scheme: dark) {
+			body { color: #ddd; background: #222; }
+			a { color: #8af; }
+		}
+		` + markdownStyle + `
+		{{ .CSS }}
+	</style>
+</head>
+<body>
+	<h1>Index of {{ .Path }}</h1>
+	<table>
+		<tr>
+			<th><a href="{{ .SortURL "name" }}">Name</a></th>
+			<th><a href="{{ .SortURL "size" }}">Size</a></th>
+			<th><a href="{{ .SortURL "time" }}">Modified</a></th>
+		</tr>
+		{{ if .Parent }}
+		<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+		{{ end }}
+		{{ range .Entries }}
+		<tr>
+			<td><a href="{{ .URL }}">{{ .Name }}</a></td>
+			<td class="size">{{ if not .IsDir }}{{ size .Size }}{{ end }}</td>
+			<td>{{ time .ModTime }}</td>
+		</tr>
+		{{ end }}
+	</table>
+	{{ with .Readme }}
+	<article class="markdown">{{ . }}</article>
+	{{ end }}
+</body>
+</html>
+`))
@@ -0,0 +1,187 @@
+package fileserver
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	mdhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// readmeNames are the files that are rendered below directory listings.
+var readmeNames = []string{"README.md", "readme.md", "Readme.md"}
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(
+			highlighting.WithFormatOptions(html.WithClasses(true)),
+		),
+	),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	// The files are our own, so there's no harm in trusting their HTML.
+	goldmark.WithRendererOptions(mdhtml.WithUnsafe()),
+)
+
+// highlightCSS is the stylesheet for highlighted code blocks, in both light
+// and dark mode.
+var highlightCSS = func() template.CSS {
+	var b strings.Builder
+	formatter := html.New(html.WithClasses(true))
+	formatter.WriteCSS(&b, styles.Get("github"))
+	b.WriteString("@media (prefers-color-scheme: dark) {\n")
+	formatter.WriteCSS(&b, styles.Get("monokai"))
+	b.WriteString("}\n")
+	return template.CSS(b.String())
+}()
+
+// renderMarkdown renders the Markdown source into HTML. Relative links and
+// images are made absolute using upath, which is the URL path of the file, so
+// that they still work when the file isn't served at its own path, such as
+// for clean URLs or READMEs below directory listings.
+func renderMarkdown(src []byte, upath string) (template.HTML, error) {
+	doc := markdown.Parser().Parse(text.NewReader(src))
+
+	dir := path.Dir(upath)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = resolveLink(dir, n.Destination)
+		case *ast.Image:
+			n.Destination = resolveLink(dir, n.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	var out bytes.Buffer
+	if err := markdown.Renderer().Render(&out, src, doc); err != nil {
+		return "", err
+	}
+	return template.HTML(out.String()), nil
+}
+
+// resolveLink makes the link absolute if it's relative to dir. Links with a
+// scheme, absolute paths and fragments are left alone.
+func resolveLink(dir string, dest []byte) []byte {
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return dest
+	}
+
+	resolved := path.Join(dir, u.Path)
+	if strings.HasSuffix(u.Path, "/") {
+		resolved += "/"
+	}
+	u.Path = resolved
+	return []byte(u.String())
+}
+
+// serveMarkdown serves the Markdown file as a page.
+func (s *Server) serveMarkdown(w http.ResponseWriter, r *http.Request, name, upath string) {
+	src, err := os.ReadFile(name)
+	if err != nil {
+		s.logger.Error("cannot read file", "err", err)
+		http.Error(w, "cannot read file", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := renderMarkdown(src, upath)
+	if err != nil {
+		s.logger.Error("cannot render markdown", "err", err)
+		http.Error(w, "cannot render markdown", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	err = markdownPage.Execute(w, struct {
+		Title string
+		Body  template.HTML
+		CSS   template.CSS
+	}{
+		Title: filepath.Base(name),
+		Body:  body,
+		CSS:   highlightCSS,
+	})
+	if err != nil {
+		s.logger.Error("cannot render markdown page", "err", err)
+	}
+}
+
+// renderReadme renders the README in the directory, if any.
+func (s *Server) renderReadme(upath string) template.HTML {
+	for _, readme := range readmeNames {
+		name := s.lookupFile(path.Join(upath, readme))
+		if name == "" {
+			continue
+		}
+
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return ""
+		}
+
+		body, err := renderMarkdown(src, path.Join(upath, readme))
+		if err != nil {
+			s.logger.Error("cannot render markdown", "err", err)
+			return ""
+		}
+		return body
+	}
+	return ""
+}
+
+// markdownStyle styles rendered Markdown similarly to GitHub.
+const markdownStyle = `
+	.markdown { line-height: 1.5; }
+	.markdown h1, .markdown h2 { border-bottom: 1px solid #8884; padding-bottom: 0.3em; }
+	.markdown code { background: #8882; padding: 0.1em 0.3em; border-radius: 4px; font-size: 0.9em; }
+	.markdown pre { background: #8881; padding: 1em; border-radius: 6px; overflow: auto; }
+	.markdown pre code { background: none; padding: 0; }
+	.markdown pre.chroma { background: #8881; }
+	.markdown blockquote { margin: 0; padding: 0 1em; border-left: 0.25em solid #8886; opacity: 0.8; }
+	.markdown table { border-collapse: collapse; }
+	.markdown th, .markdown td { border: 1px solid #8884; padding: 0.3em 0.8em; }
+	.markdown img { max-width: 100%; }
+`
+
+var markdownPage = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{ .Title }}</title>
+	<style>
+		body { font-family: system-ui, sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; }
+		@media (prefers-color-scheme: dark) {
+			body { color: #ddd; background: #222; }
+			a { color: #8af; }
+		}
+		` + markdownStyle + `
+		{{ .CSS }}
+	</style>
+</head>
+<body>
+	<article class="markdown">{{ .Body }}</article>
+</body>
+</html>
+`))
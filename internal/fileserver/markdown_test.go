@@ -0,0 +1,141 @@
+package fileserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveLink(t *testing.T) {
+	tests := []struct {
+		dir  string
+		dest string
+		want string
+	}{
+		{"/docs", "guide.md", "/docs/guide.md"},
+		{"/docs", "./img/a.png", "/docs/img/a.png"},
+		{"/docs/api", "../guide.md#install", "/docs/guide.md#install"},
+		{"/docs", "sub/", "/docs/sub/"},
+		{"/", "a.md?raw", "/a.md?raw"},
+		{"/docs", "/abs.md", "/abs.md"},
+		{"/docs", "#section", "#section"},
+		{"/docs", "https://example.com/a", "https://example.com/a"},
+		{"/docs", "//example.com/a", "//example.com/a"},
+		{"/docs", "mailto:a@example.com", "mailto:a@example.com"},
+	}
+
+	for _, test := range tests {
+		if got := string(resolveLink(test.dir, []byte(test.dest))); got != test.want {
+			t.Errorf("resolveLink(%q, %q) = %q, want %q", test.dir, test.dest, got, test.want)
+		}
+	}
+}
+
+func TestServerMarkdown(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"docs/guide.md":  "# Guide\n\nSee [the API](api.md) and ![logo](img/logo.png).\n",
+		"docs/README.md": "# Docs\n\nStart with [the guide](guide.md).\n",
+		"docs/api.md":    "# API\n",
+		"notes.txt":      "*not markdown*",
+	})
+
+	tests := []struct {
+		name        string
+		opts        Opts
+		target      string
+		contentType string
+		// contains are the strings that the body must have, in order.
+		contains []string
+		excludes []string
+	}{
+		{
+			name:        "rendered",
+			opts:        Opts{Markdown: true},
+			target:      "/docs/guide.md",
+			contentType: "text/html; charset=utf-8",
+			contains: []string{
+				"<title>guide.md</title>",
+				`<h1 id="guide">Guide</h1>`,
+				`<a href="/docs/api.md">the API</a>`,
+				`<img src="/docs/img/logo.png" alt="logo">`,
+			},
+		},
+		{
+			name:        "raw",
+			opts:        Opts{Markdown: true},
+			target:      "/docs/guide.md?raw",
+			contentType: "text/markdown; charset=utf-8",
+			contains:    []string{"# Guide\n"},
+			excludes:    []string{"<h1"},
+		},
+		{
+			name:        "disabled",
+			target:      "/docs/guide.md",
+			contentType: "text/markdown; charset=utf-8",
+			contains:    []string{"# Guide\n"},
+		},
+		{
+			name:        "not markdown",
+			opts:        Opts{Markdown: true},
+			target:      "/notes.txt",
+			contentType: "text/plain; charset=utf-8",
+			contains:    []string{"*not markdown*"},
+		},
+		{
+			name:        "clean url",
+			opts:        Opts{Markdown: true, CleanURLs: true},
+			target:      "/docs/guide",
+			contentType: "text/html; charset=utf-8",
+			contains:    []string{`<a href="/docs/api.md">the API</a>`},
+		},
+		{
+			name:        "readme below listing",
+			opts:        Opts{Markdown: true},
+			target:      "/docs/",
+			contentType: "text/html; charset=utf-8",
+			contains: []string{
+				"api.md",
+				"guide.md",
+				`<h1 id="docs">Docs</h1>`,
+				`<a href="/docs/guide.md">the guide</a>`,
+			},
+		},
+		{
+			name:        "listing without markdown",
+			target:      "/docs/",
+			contentType: "text/html; charset=utf-8",
+			contains:    []string{"guide.md"},
+			excludes:    []string{`<h1 id="docs">`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewServer([]Mount{{Prefix: "/", Dir: dir}}, test.opts)
+			w := serve(s, "GET", test.target)
+
+			if w.Code != 200 {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); got != test.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, test.contentType)
+			}
+
+			body := w.Body.String()
+			rest := body
+			for _, s := range test.contains {
+				i := strings.Index(rest, s)
+				if i == -1 {
+					t.Errorf("body doesn't have %q after the previous strings:\n%s", s, body)
+					break
+				}
+				rest = rest[i+len(s):]
+			}
+			for _, s := range test.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("body has %q:\n%s", s, body)
+				}
+			}
+		})
+	}
+}
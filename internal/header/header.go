@@ -0,0 +1,192 @@
+// Package header overrides response headers and handles CORS for the target
+// server.
+package header
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+	"libdb.so/saq/internal/proxy"
+)
+
+// Rule sets a response header on responses to requests matching Pattern.
+type Rule struct {
+	// Pattern is matched against the request path using proxy.MatchPath. An
+	// empty pattern matches everything.
+	Pattern string
+	Name    string
+	// Value is the value to set. If empty, the header is removed instead.
+	Value string
+}
+
+// ParseRule parses a rule in the form of
+//
+//	[/pattern ]Name: value
+//
+// such as "Cache-Control: no-store" or "/api/** Access-Control-Allow-Origin: *".
+func ParseRule(s string) (Rule, error) {
+	var rule Rule
+
+	if strings.HasPrefix(s, "/") {
+		pattern, rest, ok := strings.Cut(s, " ")
+		if !ok {
+			return rule, fmt.Errorf("rule %q has a pattern but no header", s)
+		}
+		if err := proxy.CheckPathPattern(pattern); err != nil {
+			return rule, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		rule.Pattern = pattern
+		s = rest
+	}
+
+	name, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return rule, fmt.Errorf("header %q is missing :", s)
+	}
+
+	rule.Name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	rule.Value = strings.TrimSpace(value)
+
+	if !httpguts.ValidHeaderFieldName(rule.Name) {
+		return rule, fmt.Errorf("invalid header name %q", rule.Name)
+	}
+	if !httpguts.ValidHeaderFieldValue(rule.Value) {
+		return rule, fmt.Errorf("invalid header value %q", rule.Value)
+	}
+
+	return rule, nil
+}
+
+func (r Rule) matches(path string) bool {
+	return r.Pattern == "" || proxy.MatchPath(r.Pattern, path)
+}
+
+// Opts are the options for a Rewriter.
+type Opts struct {
+	// Rules are applied in order, so later rules override earlier ones.
+	Rules []Rule
+	// CORS allows cross-origin requests from any origin, with credentials.
+	CORS bool
+}
+
+// Rewriter rewrites the response headers of a handler.
+type Rewriter struct {
+	opts Opts
+}
+
+// NewRewriter creates a new Rewriter.
+func NewRewriter(opts Opts) *Rewriter {
+	return &Rewriter{opts: opts}
+}
+
+// Wrap wraps the handler so that its responses have their headers rewritten.
+// CORS preflight requests are answered without calling the handler if CORS is
+// enabled or if a rule sets Access-Control-Allow-Origin.
+func (rw *Rewriter) Wrap(h http.Handler) http.Handler {
+	if !rw.opts.CORS && len(rw.opts.Rules) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headerWriter{ResponseWriter: w, rw: rw, r: r}
+
+		if isPreflight(r) && rw.allowsCORS(r.URL.Path) {
+			header := w.Header()
+			header.Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				header.Set("Access-Control-Allow-Headers", headers)
+			}
+			header.Set("Access-Control-Max-Age", "600")
+			hw.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.ServeHTTP(hw, r)
+
+		// Nothing was written, so net/http will write the header itself.
+		if !hw.applied {
+			rw.apply(w.Header(), r)
+		}
+	})
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (rw *Rewriter) allowsCORS(path string) bool {
+	if rw.opts.CORS {
+		return true
+	}
+	for _, rule := range rw.opts.Rules {
+		if rule.Name == "Access-Control-Allow-Origin" && rule.Value != "" && rule.matches(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rw *Rewriter) apply(h http.Header, r *http.Request) {
+	if origin := r.Header.Get("Origin"); rw.opts.CORS && origin != "" {
+		// Expose everything, since "*" doesn't work with credentials.
+		var names []string
+		for name := range h {
+			if !strings.HasPrefix(name, "Access-Control-") {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			sort.Strings(names)
+			h.Set("Access-Control-Expose-Headers", strings.Join(names, ", "))
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+		h.Add("Vary", "Origin")
+	}
+
+	for _, rule := range rw.opts.Rules {
+		if !rule.matches(r.URL.Path) {
+			continue
+		}
+		if rule.Value == "" {
+			h.Del(rule.Name)
+		} else {
+			h.Set(rule.Name, rule.Value)
+		}
+	}
+}
+
+// headerWriter applies the rules right before the header is written.
+type headerWriter struct {
+	http.ResponseWriter
+	rw      *Rewriter
+	r       *http.Request
+	applied bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	// Informational responses come before the actual one.
+	if !w.applied && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.applied = true
+		w.rw.apply(w.Header(), w.r)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	if !w.applied {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
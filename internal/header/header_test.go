@@ -0,0 +1,220 @@
+package header
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rule
+		wantErr bool
+	}{
+		{
+			in:   "Cache-Control: no-store",
+			want: Rule{Name: "Cache-Control", Value: "no-store"},
+		},
+		{
+			in:   "x-frame-options:DENY",
+			want: Rule{Name: "X-Frame-Options", Value: "DENY"},
+		},
+		{
+			in:   "/api/** Access-Control-Allow-Origin: *",
+			want: Rule{Pattern: "/api/**", Name: "Access-Control-Allow-Origin", Value: "*"},
+		},
+		{
+			in:   "Content-Security-Policy: default-src 'self'; img-src https://example.com",
+			want: Rule{Name: "Content-Security-Policy", Value: "default-src 'self'; img-src https://example.com"},
+		},
+		{
+			in:   "Server:",
+			want: Rule{Name: "Server"},
+		},
+		{
+			in:   "/assets/** Server:",
+			want: Rule{Pattern: "/assets/**", Name: "Server"},
+		},
+		{in: "Cache-Control", wantErr: true},
+		{in: "/api/**", wantErr: true},
+		{in: "/api/** Cache-Control", wantErr: true},
+		{in: ": no-store", wantErr: true},
+		{in: "Bad Name: value", wantErr: true},
+		{in: "X-Foo: a\x00b", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			rule, err := ParseRule(test.in)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if rule != test.want {
+				t.Errorf("rule = %+v, want %+v", rule, test.want)
+			}
+		})
+	}
+}
+
+func TestRewriterApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Opts
+		path   string
+		origin string
+		in     http.Header
+		want   http.Header
+	}{
+		{
+			name: "set and remove",
+			opts: Opts{Rules: []Rule{
+				{Name: "Cache-Control", Value: "no-store"},
+				{Name: "Etag"},
+			}},
+			path: "/",
+			in:   http.Header{"Etag": {`"1"`}, "Cache-Control": {"max-age=60"}},
+			want: http.Header{"Cache-Control": {"no-store"}},
+		},
+		{
+			name: "pattern",
+			opts: Opts{Rules: []Rule{
+				{Pattern: "/api/**", Name: "X-Debug", Value: "1"},
+			}},
+			path: "/index.html",
+			in:   http.Header{},
+			want: http.Header{},
+		},
+		{
+			name: "later rules win",
+			opts: Opts{Rules: []Rule{
+				{Name: "X-Debug", Value: "1"},
+				{Pattern: "/api/**", Name: "X-Debug", Value: "2"},
+			}},
+			path: "/api/users",
+			in:   http.Header{},
+			want: http.Header{"X-Debug": {"2"}},
+		},
+		{
+			name:   "cors",
+			opts:   Opts{CORS: true},
+			path:   "/api/users",
+			origin: "http://localhost:3000",
+			in:     http.Header{"Content-Type": {"application/json"}, "X-Total": {"2"}},
+			want: http.Header{
+				"Content-Type":                     {"application/json"},
+				"X-Total":                          {"2"},
+				"Access-Control-Allow-Origin":      {"http://localhost:3000"},
+				"Access-Control-Allow-Credentials": {"true"},
+				"Access-Control-Expose-Headers":    {"Content-Type, X-Total"},
+				"Vary":                             {"Origin"},
+			},
+		},
+		{
+			name: "cors without origin",
+			opts: Opts{CORS: true},
+			path: "/api/users",
+			in:   http.Header{"Content-Type": {"application/json"}},
+			want: http.Header{"Content-Type": {"application/json"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.path, nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+
+			NewRewriter(test.opts).apply(test.in, r)
+			if !reflect.DeepEqual(test.in, test.want) {
+				t.Errorf("header = %v, want %v", test.in, test.want)
+			}
+		})
+	}
+}
+
+func TestRewriterPreflight(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Opts
+		path      string
+		preflight bool
+	}{
+		{
+			name:      "cors",
+			opts:      Opts{CORS: true},
+			path:      "/api/users",
+			preflight: true,
+		},
+		{
+			name: "allow origin rule",
+			opts: Opts{Rules: []Rule{
+				{Pattern: "/api/**", Name: "Access-Control-Allow-Origin", Value: "*"},
+			}},
+			path:      "/api/users",
+			preflight: true,
+		},
+		{
+			name: "allow origin rule elsewhere",
+			opts: Opts{Rules: []Rule{
+				{Pattern: "/api/**", Name: "Access-Control-Allow-Origin", Value: "*"},
+			}},
+			path: "/index.html",
+		},
+		{
+			name: "no cors",
+			opts: Opts{Rules: []Rule{{Name: "X-Debug", Value: "1"}}},
+			path: "/api/users",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var reached bool
+			h := NewRewriter(test.opts).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}))
+
+			r := httptest.NewRequest("OPTIONS", test.path, nil)
+			r.Header.Set("Origin", "http://localhost:3000")
+			r.Header.Set("Access-Control-Request-Method", "PUT")
+			r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if reached == test.preflight {
+				t.Fatalf("handler reached = %v, want %v", reached, !test.preflight)
+			}
+			if !test.preflight {
+				return
+			}
+
+			if w.Code != http.StatusNoContent {
+				t.Errorf("status = %d, want 204", w.Code)
+			}
+			want := map[string]string{
+				"Access-Control-Allow-Methods": "PUT",
+				"Access-Control-Allow-Headers": "Content-Type",
+				"Access-Control-Max-Age":       "600",
+			}
+			for name, value := range want {
+				if got := w.Header().Get(name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+			if w.Header().Get("Access-Control-Allow-Origin") == "" {
+				t.Error("Access-Control-Allow-Origin is not set")
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package inspect
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR types, following the HTTP Archive 1.2 spec. Only the fields that we can
+// fill in are included.
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// WriteHAR writes the entries as a HAR file.
+func WriteHAR(w io.Writer, entries []*Entry) error {
+	har := harFile{
+		Log: harLog{
+			Version: "1.2",
+			Creator: harCreator{Name: "saq", Version: "1"},
+			Entries: make([]harEntry, len(entries)),
+		},
+	}
+
+	// HAR entries are oldest first.
+	for i, entry := range entries {
+		har.Log.Entries[len(entries)-1-i] = newHAREntry(entry)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(har)
+}
+
+func newHAREntry(entry *Entry) harEntry {
+	u, _ := url.Parse(entry.URL)
+
+	req := harRequest{
+		Method:      entry.Method,
+		URL:         entry.URL,
+		HTTPVersion: entry.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(entry.Request.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    entry.Request.Size,
+	}
+
+	if u != nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				req.QueryString = append(req.QueryString, harNameValue{name, value})
+			}
+		}
+	}
+
+	for _, cookie := range (&http.Request{Header: entry.Request.Header}).Cookies() {
+		req.Cookies = append(req.Cookies, harNameValue{cookie.Name, cookie.Value})
+	}
+
+	if entry.Request.Size > 0 {
+		body, _ := entry.Request.DecodedBody()
+		req.PostData = &harPostData{
+			MimeType: entry.Request.Header.Get("Content-Type"),
+			Text:     string(body),
+		}
+	}
+
+	resp := harResponse{
+		Status:      entry.Status,
+		StatusText:  http.StatusText(entry.Status),
+		HTTPVersion: entry.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(entry.Response.Header),
+		RedirectURL: entry.Response.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    entry.Response.Size,
+		Content: harContent{
+			MimeType: entry.Response.Header.Get("Content-Type"),
+		},
+	}
+
+	for _, cookie := range (&http.Response{Header: entry.Response.Header}).Cookies() {
+		resp.Cookies = append(resp.Cookies, harNameValue{cookie.Name, cookie.Value})
+	}
+
+	if body, ok := entry.Response.DecodedBody(); ok {
+		resp.Content.Size = int64(len(body))
+		if isText(resp.Content.MimeType) && utf8.Valid(body) {
+			resp.Content.Text = string(body)
+		} else {
+			resp.Content.Text = base64.StdEncoding.EncodeToString(body)
+			resp.Content.Encoding = "base64"
+		}
+		if entry.Response.Truncated {
+			resp.Content.Comment = "truncated"
+		}
+	} else {
+		resp.Content.Size = entry.Response.Size
+		resp.Content.Comment = "body is truncated and compressed"
+	}
+
+	wait := durationMs(entry.Wait)
+	total := durationMs(entry.Duration)
+
+	return harEntry{
+		StartedDateTime: entry.Started.Format(time.RFC3339Nano),
+		Time:            total,
+		Request:         req,
+		Response:        resp,
+		Timings: harTimings{
+			Wait:    wait,
+			Receive: total - wait,
+		},
+	}
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range h {
+		for _, value := range values {
+			headers = append(headers, harNameValue{name, value})
+		}
+	}
+	return headers
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func isText(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") ||
+		strings.HasSuffix(mediaType, "javascript")
+}
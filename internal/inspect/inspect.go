@@ -0,0 +1,242 @@
+// Package inspect records recent HTTP requests and responses going through
+// the proxy so that they can be browsed and exported as HAR.
+package inspect
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"libdb.so/saq/internal/proxy"
+)
+
+// Entry is a recorded request and its response.
+type Entry struct {
+	ID       int64
+	Started  time.Time
+	Wait     time.Duration // until the response header was written
+	Duration time.Duration // until the response was done
+	Method   string
+	URL      string
+	Proto    string
+	Request  Message
+	Response Message
+	Status   int
+	Hijacked bool
+	RemoteIP string
+}
+
+// Message is the header and body of a request or response.
+type Message struct {
+	Header http.Header
+	// Body is the first part of the body as it went over the wire, so it may
+	// be compressed.
+	Body []byte
+	// Size is the full size of the body.
+	Size int64
+	// Truncated is true if Body is not the full body.
+	Truncated bool
+}
+
+// DecodedBody returns the body with its Content-Encoding undone. If the body
+// cannot be decoded, such as because it's truncated, then false is returned.
+func (m Message) DecodedBody() ([]byte, bool) {
+	if m.Truncated && m.Header.Get("Content-Encoding") != "" {
+		return nil, false
+	}
+	b, err := proxy.DecodeBody(m.Header, m.Body)
+	return b, err == nil
+}
+
+// Recorder keeps a ring buffer of the most recent entries.
+type Recorder struct {
+	mu      sync.Mutex
+	entries []*Entry // ring buffer
+	next    int
+	lastID  int64
+	bodyMax int64
+}
+
+// NewRecorder creates a new recorder that keeps up to size entries, each with
+// up to bodyMax bytes of the request and response bodies.
+func NewRecorder(size int, bodyMax int64) *Recorder {
+	return &Recorder{
+		entries: make([]*Entry, 0, size),
+		bodyMax: bodyMax,
+	}
+}
+
+// Entries returns the recorded entries, newest first.
+func (rec *Recorder) Entries() []*Entry {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	entries := make([]*Entry, 0, len(rec.entries))
+	for i := 1; i <= len(rec.entries); i++ {
+		j := (rec.next - i + len(rec.entries)) % len(rec.entries)
+		entries = append(entries, rec.entries[j])
+	}
+	return entries
+}
+
+// Entry returns the entry with the given ID, or nil if it's gone.
+func (rec *Recorder) Entry(id int64) *Entry {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	for _, entry := range rec.entries {
+		if entry.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Clear removes all entries.
+func (rec *Recorder) Clear() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.entries = rec.entries[:0]
+	rec.next = 0
+}
+
+func (rec *Recorder) add(entry *Entry) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.lastID++
+	entry.ID = rec.lastID
+
+	if len(rec.entries) < cap(rec.entries) {
+		rec.entries = append(rec.entries, entry)
+	} else {
+		rec.entries[rec.next] = entry
+	}
+	rec.next = (rec.next + 1) % cap(rec.entries)
+}
+
+// Wrap wraps the handler so that its requests are recorded.
+func (rec *Recorder) Wrap(h http.Handler) http.Handler {
+	if cap(rec.entries) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		entry := &Entry{
+			Started: time.Now(),
+			Method:  r.Method,
+			URL:     scheme + "://" + r.Host + r.URL.RequestURI(),
+			Proto:   r.Proto,
+			Request: Message{Header: r.Header.Clone()},
+		}
+		entry.RemoteIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+
+		var reqBody *capture
+		if r.Body != nil && r.Body != http.NoBody {
+			reqBody = &capture{max: rec.bodyMax}
+			r.Body = readCloser{io.TeeReader(r.Body, reqBody), r.Body}
+		}
+
+		rw := &recordingWriter{
+			ResponseWriter: w,
+			entry:          entry,
+			body:           capture{max: rec.bodyMax},
+		}
+
+		h.ServeHTTP(rw, r)
+
+		entry.Duration = time.Since(entry.Started)
+		if rw.status == 0 && !entry.Hijacked {
+			// Nothing was written, so net/http will write a 200.
+			entry.Status = http.StatusOK
+			entry.Wait = entry.Duration
+			entry.Response.Header = w.Header().Clone()
+		}
+
+		if reqBody != nil {
+			entry.Request.Body = reqBody.buf
+			entry.Request.Size = reqBody.size
+			entry.Request.Truncated = reqBody.size > int64(len(reqBody.buf))
+		}
+		entry.Response.Body = rw.body.buf
+		entry.Response.Size = rw.body.size
+		entry.Response.Truncated = rw.body.size > int64(len(rw.body.buf))
+
+		rec.add(entry)
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// capture keeps the first max bytes written to it while counting all of them.
+type capture struct {
+	buf  []byte
+	size int64
+	max  int64
+}
+
+func (c *capture) Write(b []byte) (int, error) {
+	if room := c.max - int64(len(c.buf)); room > 0 {
+		if int64(len(b)) > room {
+			c.buf = append(c.buf, b[:room]...)
+		} else {
+			c.buf = append(c.buf, b...)
+		}
+	}
+	c.size += int64(len(b))
+	return len(b), nil
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	entry  *Entry
+	body   capture
+	status int
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	// Informational responses come before the actual one.
+	if w.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.status = code
+		w.entry.Status = code
+		w.entry.Wait = time.Since(w.entry.Started)
+		w.entry.Response.Header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.entry.Hijacked = true
+		w.entry.Wait = time.Since(w.entry.Started)
+		w.entry.Status = http.StatusSwitchingProtocols
+		w.entry.Response.Header = w.Header().Clone()
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
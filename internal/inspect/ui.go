@@ -0,0 +1,193 @@
+package inspect
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Path is the path that the inspector UI is served at.
+const Path = "/__saq/requests"
+
+// Mount mounts the inspector UI onto the mux at Path.
+func (rec *Recorder) Mount(mux *http.ServeMux) {
+	h := rec.Handler()
+	mux.Handle(Path, h)
+	mux.Handle(Path+"/", h)
+	mux.Handle(Path+".har", h)
+}
+
+// Handler returns the handler for the inspector UI. Use Mount to mount it
+// onto all the paths that it handles.
+func (rec *Recorder) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch rest := strings.TrimPrefix(r.URL.Path, Path); rest {
+		case "", "/":
+			rec.serveList(w, r)
+		case ".har", "/export.har":
+			rec.serveHAR(w, r)
+		case "/clear":
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			rec.Clear()
+			http.Redirect(w, r, Path, http.StatusSeeOther)
+		default:
+			id, err := strconv.ParseInt(strings.TrimPrefix(rest, "/"), 10, 64)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			rec.serveEntry(w, r, id)
+		}
+	})
+}
+
+func (rec *Recorder) serveHAR(w http.ResponseWriter, r *http.Request) {
+	name := fmt.Sprintf("saq-%s.har", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	WriteHAR(w, rec.Entries())
+}
+
+func (rec *Recorder) serveList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	listPage.Execute(w, rec.Entries())
+}
+
+func (rec *Recorder) serveEntry(w http.ResponseWriter, r *http.Request, id int64) {
+	entry := rec.Entry(id)
+	if entry == nil {
+		http.Error(w, "request is gone from the buffer", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	entryPage.Execute(w, entry)
+}
+
+var funcs = template.FuncMap{
+	"ms": func(d time.Duration) string {
+		return d.Round(100 * time.Microsecond).String()
+	},
+	"clock": func(t time.Time) string {
+		return t.Format("15:04:05.000")
+	},
+	"statusText": http.StatusText,
+	"body": func(m Message) string {
+		b, ok := m.DecodedBody()
+		switch {
+		case !ok:
+			return "(compressed body is truncated)"
+		case len(b) == 0:
+			return ""
+		case !utf8.Valid(b):
+			return fmt.Sprintf("(%d bytes of binary)", len(b))
+		default:
+			return string(b)
+		}
+	},
+}
+
+const style = `
+<style>
+	body { font-family: system-ui, sans-serif; margin: 1em; }
+	table { border-collapse: collapse; width: 100%; }
+	th, td { text-align: left; padding: 0.2em 0.5em; border-bottom: 1px solid #8884; }
+	td.path { word-break: break-all; }
+	pre { white-space: pre-wrap; word-break: break-all; background: #8881; padding: 0.5em; }
+	.status-ok { color: green; }
+	.status-redirect { color: goldenrod; }
+	.status-error { color: red; }
+	header { display: flex; gap: 1em; align-items: baseline; }
+	@media (prefers-color-scheme: dark) {
+		body { color: #ddd; background: #222; }
+		a { color: #8af; }
+	}
+</style>
+`
+
+var listPage = template.Must(template.New("list").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>saq requests</title>` + style + `
+</head>
+<body>
+	<header>
+		<h1>Requests</h1>
+		<a href="` + Path + `">Refresh</a>
+		<a href="` + Path + `.har">Export HAR</a>
+		<form method="post" action="` + Path + `/clear"><button>Clear</button></form>
+	</header>
+	<table>
+		<tr>
+			<th>Time</th>
+			<th>Method</th>
+			<th>URL</th>
+			<th>Status</th>
+			<th>Type</th>
+			<th>Size</th>
+			<th>Duration</th>
+		</tr>
+		{{ range . }}
+		<tr>
+			<td>{{ clock .Started }}</td>
+			<td>{{ .Method }}</td>
+			<td class="path"><a href="` + Path + `/{{ .ID }}">{{ .URL }}</a></td>
+			<td class="{{ if ge .Status 400 }}status-error{{ else if ge .Status 300 }}status-redirect{{ else }}status-ok{{ end }}">{{ .Status }}</td>
+			<td>{{ .Response.Header.Get "Content-Type" }}</td>
+			<td>{{ .Response.Size }}</td>
+			<td>{{ ms .Duration }}</td>
+		</tr>
+		{{ else }}
+		<tr><td colspan="7">No requests yet.</td></tr>
+		{{ end }}
+	</table>
+</body>
+</html>
+`))
+
+var entryPage = template.Must(template.New("entry").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{ .Method }} {{ .URL }}</title>` + style + `
+</head>
+<body>
+	<header>
+		<a href="` + Path + `">&larr; Requests</a>
+	</header>
+	<h1>{{ .Method }} {{ .URL }}</h1>
+	<p>
+		{{ .Status }} {{ statusText .Status }} &middot; {{ .Proto }} &middot;
+		started {{ clock .Started }} &middot;
+		waited {{ ms .Wait }} &middot; took {{ ms .Duration }}
+		{{ with .RemoteIP }}&middot; from {{ . }}{{ end }}
+	</p>
+
+	<h2>Request</h2>
+	<pre>{{ range $k, $vs := .Request.Header }}{{ range $vs }}{{ $k }}: {{ . }}
+{{ end }}{{ end }}</pre>
+	{{ if .Request.Size }}
+	<h3>Body ({{ .Request.Size }} bytes{{ if .Request.Truncated }}, truncated{{ end }})</h3>
+	<pre>{{ body .Request }}</pre>
+	{{ end }}
+
+	<h2>Response</h2>
+	<pre>{{ range $k, $vs := .Response.Header }}{{ range $vs }}{{ $k }}: {{ . }}
+{{ end }}{{ end }}</pre>
+	{{ if .Response.Size }}
+	<h3>Body ({{ .Response.Size }} bytes{{ if .Response.Truncated }}, truncated{{ end }})</h3>
+	<pre>{{ body .Response }}</pre>
+	{{ end }}
+</body>
+</html>
+`))
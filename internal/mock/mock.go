@@ -0,0 +1,298 @@
+// Package mock serves stub responses from files ahead of the proxy, so that
+// endpoints can be faked before the backend implements them.
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"libdb.so/saq/internal/proxy"
+)
+
+// ConfigName is the name of the config file that is read from a mock
+// directory.
+const ConfigName = "mocks.json"
+
+// Mock is a stub response described in the config file.
+type Mock struct {
+	// Method is the request method to match. Empty matches any method.
+	Method string `json:"method,omitempty"`
+	// Path is matched against the request path using proxy.MatchPath.
+	Path string `json:"path"`
+	// Status is the response status, defaulting to 200.
+	Status int `json:"status,omitempty"`
+	// Headers are the response headers.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Delay is how long to wait before responding, such as "200ms".
+	Delay string `json:"delay,omitempty"`
+	// File is the file to respond with, relative to the config file.
+	File string `json:"file,omitempty"`
+	// Body is the response body if there is no File.
+	Body string `json:"body,omitempty"`
+}
+
+// Server serves mocks. The config is only read again when Reload is called,
+// which should be done whenever Dir or Files change. If the config stops
+// parsing, the last good one keeps being used until it's fixed.
+type Server struct {
+	dir    string
+	config string
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	last    []Mock
+	lastErr string
+}
+
+// NewServer creates a new mock server. The path is either a directory, which
+// serves the files in it by their path and reads mocks.json in it if there is
+// one, or a config file.
+func NewServer(path string) (*Server, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		config: filepath.Clean(path),
+		logger: slog.With("component", "mock"),
+	}
+	if stat.IsDir() {
+		s.dir = filepath.Clean(path)
+		s.config = filepath.Join(s.dir, ConfigName)
+	}
+
+	mocks, err := s.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	s.last = mocks
+	return s, nil
+}
+
+// Dir returns the mock directory, or an empty string if the mocks come from a
+// config file.
+func (s *Server) Dir() string {
+	return s.dir
+}
+
+// File returns the config file if the mocks come from one instead of a
+// directory.
+func (s *Server) File() string {
+	if s.dir != "" {
+		return ""
+	}
+	return s.config
+}
+
+// Files returns the files outside of Dir that the mocks are read from, which
+// are the config file and the files that it names. They change as the config
+// does.
+func (s *Server) Files() []string {
+	var files []string
+	if s.dir == "" {
+		files = append(files, s.config)
+	}
+
+	for _, mock := range s.mocks() {
+		if mock.File == "" {
+			continue
+		}
+		file := s.mockFile(mock)
+		if s.dir != "" && isWithin(s.dir, file) {
+			continue
+		}
+		if !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// isWithin returns true if the file is in the directory or any of its
+// subdirectories.
+func isWithin(dir, file string) bool {
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Reload reads the config again. If it cannot be loaded, the last good one
+// is kept.
+func (s *Server) Reload() {
+	mocks, err := s.loadConfig()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		// Only log each error once instead of on every change.
+		if msg := err.Error(); msg != s.lastErr {
+			s.lastErr = msg
+			s.logger.Error("cannot load mocks, using the last good ones", "err", err)
+		}
+		return
+	}
+
+	if s.lastErr != "" {
+		s.lastErr = ""
+		s.logger.Info("mocks loaded again")
+	}
+	s.last = mocks
+}
+
+// mocks returns the mocks from the last good config.
+func (s *Server) mocks() []Mock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+func (s *Server) loadConfig() ([]Mock, error) {
+	b, err := os.ReadFile(s.config)
+	if err != nil {
+		// The config is optional in a mock directory.
+		if s.dir != "" && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var mocks []Mock
+	if err := json.Unmarshal(b, &mocks); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.config, err)
+	}
+
+	for _, mock := range mocks {
+		if err := proxy.CheckPathPattern(mock.Path); err != nil {
+			return nil, fmt.Errorf("%s: invalid path %q: %w", s.config, mock.Path, err)
+		}
+		if mock.Delay != "" {
+			if _, err := time.ParseDuration(mock.Delay); err != nil {
+				return nil, fmt.Errorf("%s: invalid delay %q: %w", s.config, mock.Delay, err)
+			}
+		}
+	}
+
+	return mocks, nil
+}
+
+// Wrap wraps the handler so that requests with a mock are served by it
+// instead.
+func (s *Server) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, mock := range s.mocks() {
+			if (mock.Method == "" || strings.EqualFold(mock.Method, r.Method)) &&
+				proxy.MatchPath(mock.Path, r.URL.Path) {
+				s.serveMock(w, r, mock)
+				return
+			}
+		}
+
+		if s.dir != "" {
+			if file := s.findFile(r); file != "" {
+				s.logger.Debug("serving mock", "file", file, "path", r.URL.Path)
+				w.Header().Set("Cache-Control", "no-store")
+				http.ServeFile(w, r, file)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// findFile finds the file in the mock directory for the request. For a
+// request to /api/users, these are tried in order:
+//
+//	api/users.POST.json (with the request's method)
+//	api/users (GET and HEAD only)
+//	api/users.json (GET and HEAD only)
+func (s *Server) findFile(r *http.Request) string {
+	name := filepath.Join(s.dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+	if name == s.config {
+		return ""
+	}
+
+	candidates := []string{name + "." + r.Method + ".json"}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		candidates = append(candidates, name, name+".json")
+	}
+
+	for _, candidate := range candidates {
+		stat, err := os.Stat(candidate)
+		if err == nil && stat.Mode().IsRegular() {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// mockFile returns the path to the mock's file, which is relative to the
+// config file unless it's absolute.
+func (s *Server) mockFile(mock Mock) string {
+	if filepath.IsAbs(mock.File) {
+		return filepath.Clean(mock.File)
+	}
+	return filepath.Join(filepath.Dir(s.config), mock.File)
+}
+
+func (s *Server) serveMock(w http.ResponseWriter, r *http.Request, mock Mock) {
+	s.logger.Debug("serving mock", "mock", mock.Path, "path", r.URL.Path)
+
+	if mock.Delay != "" {
+		delay, _ := time.ParseDuration(mock.Delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	body := []byte(mock.Body)
+	if mock.File != "" {
+		file := s.mockFile(mock)
+
+		var err error
+		body, err = os.ReadFile(file)
+		if err != nil {
+			s.logger.Error("cannot read mock", "err", err)
+			http.Error(w, "cannot read mock: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if t := mime.TypeByExtension(filepath.Ext(file)); t != "" {
+			w.Header().Set("Content-Type", t)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	for k, v := range mock.Headers {
+		w.Header().Set(k, v)
+	}
+
+	status := mock.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
@@ -0,0 +1,289 @@
+package mock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles writes the files, which are named relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serve serves the request with the mocks in front of a handler that answers
+// "proxied".
+func serve(s *Server, method, path string) *httptest.ResponseRecorder {
+	h := s.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"mocks.json": `[
+			{"method": "GET", "path": "/api/users", "file": "users.json"},
+			{"method": "DELETE", "path": "/api/users/*", "status": 204},
+			{"path": "/api/legacy/**", "status": 410, "headers": {"X-Reason": "gone"}, "body": "gone"},
+			{"path": "/api/users/*", "body": "any user"}
+		]`,
+		"users.json": `[{"id": 1}]`,
+	})
+
+	s, err := NewServer(filepath.Join(dir, "mocks.json"))
+	if err != nil {
+		t.Fatal("cannot create server:", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		header http.Header
+		body   string
+	}{
+		{
+			method: "GET",
+			path:   "/api/users",
+			status: 200,
+			header: http.Header{"Content-Type": {"application/json"}},
+			body:   `[{"id": 1}]`,
+		},
+		{
+			method: "POST",
+			path:   "/api/users",
+			status: 200,
+			body:   "proxied",
+		},
+		{
+			method: "DELETE",
+			path:   "/api/users/1",
+			status: 204,
+		},
+		{
+			method: "GET",
+			path:   "/api/users/1",
+			status: 200,
+			body:   "any user",
+		},
+		{
+			method: "GET",
+			path:   "/api/users/1/posts",
+			status: 200,
+			body:   "proxied",
+		},
+		{
+			method: "PUT",
+			path:   "/api/legacy/a/b",
+			status: 410,
+			header: http.Header{"X-Reason": {"gone"}},
+			body:   "gone",
+		},
+		{
+			method: "HEAD",
+			path:   "/api/legacy/a",
+			status: 410,
+		},
+		{
+			method: "GET",
+			path:   "/mocks.json",
+			status: 200,
+			body:   "proxied",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			w := serve(s, test.method, test.path)
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+			for name := range test.header {
+				if got, want := w.Header().Get(name), test.header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if got := w.Body.String(); got != test.body {
+				t.Errorf("body = %q, want %q", got, test.body)
+			}
+		})
+	}
+}
+
+func TestServerDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"mocks.json":                 `[{"path": "/api/health", "body": "ok"}]`,
+		"api/users.json":             `"users"`,
+		"api/users.POST.json":        `"created"`,
+		"api/teams":                  `"teams"`,
+		"api/teams.json":             `"teams json"`,
+		"api/users/1.DELETE.json":    `"deleted"`,
+		"api/users/1/posts/.gitkeep": ``,
+	})
+
+	s, err := NewServer(dir)
+	if err != nil {
+		t.Fatal("cannot create server:", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/api/health", "ok"},
+		{"GET", "/api/users", `"users"`},
+		{"POST", "/api/users", `"created"`},
+		{"PUT", "/api/users", "proxied"},
+		{"GET", "/api/teams", `"teams"`},
+		{"DELETE", "/api/users/1", `"deleted"`},
+		{"GET", "/api/users/1", "proxied"},
+		{"GET", "/api/users/1/posts", "proxied"},
+		{"GET", "/mocks.json", "proxied"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			w := serve(s, test.method, test.path)
+			if got := w.Body.String(); got != test.body {
+				t.Errorf("body = %q, want %q", got, test.body)
+			}
+		})
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "mocks.json")
+	writeFiles(t, dir, map[string]string{
+		"mocks.json": `[{"path": "/a", "body": "1"}]`,
+	})
+
+	s, err := NewServer(config)
+	if err != nil {
+		t.Fatal("cannot create server:", err)
+	}
+
+	steps := []struct {
+		config string
+		body   string
+	}{
+		// Changes only take effect after a reload.
+		{`[{"path": "/a", "body": "2"}]`, "2"},
+		// The last good config is kept while the config is broken.
+		{`[{"path": "/a", "body": `, "2"},
+		{`[{"path": "/a[", "body": "3"}]`, "2"},
+		{`[{"path": "/a", "body": "4"}]`, "4"},
+	}
+
+	last := "1"
+	for _, step := range steps {
+		writeFiles(t, dir, map[string]string{"mocks.json": step.config})
+		if got := serve(s, "GET", "/a").Body.String(); got != last {
+			t.Errorf("%s: body = %q before the reload, want %q", step.config, got, last)
+		}
+
+		s.Reload()
+		if got := serve(s, "GET", "/a").Body.String(); got != step.body {
+			t.Errorf("%s: body = %q, want %q", step.config, got, step.body)
+		}
+		last = step.body
+	}
+}
+
+func TestNewServerInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"syntax", `[{"path": `},
+		{"path", `[{"path": "/api["}]`},
+		{"delay", `[{"path": "/api", "delay": "soon"}]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"mocks.json": test.config})
+
+			for _, path := range []string{dir, filepath.Join(dir, "mocks.json")} {
+				if _, err := NewServer(path); err == nil {
+					t.Errorf("%s: expected error", path)
+				}
+			}
+		})
+	}
+}
+
+func TestServerFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"mocks/mocks.json": `[
+			{"path": "/a", "file": "a.json"},
+			{"path": "/b", "file": "../fixtures/b.json"},
+			{"path": "/c", "file": "../fixtures/b.json"},
+			{"path": "/d", "file": "` + filepath.ToSlash(filepath.Join(root, "d.json")) + `"},
+			{"path": "/e", "body": "e"}
+		]`,
+	})
+
+	tests := []struct {
+		name string
+		path string
+		dir  string
+		want []string
+	}{
+		{
+			name: "directory",
+			path: filepath.Join(root, "mocks"),
+			dir:  filepath.Join(root, "mocks"),
+			want: []string{
+				filepath.Join(root, "fixtures", "b.json"),
+				filepath.Join(root, "d.json"),
+			},
+		},
+		{
+			name: "config file",
+			path: filepath.Join(root, "mocks", "mocks.json"),
+			want: []string{
+				filepath.Join(root, "mocks", "mocks.json"),
+				filepath.Join(root, "mocks", "a.json"),
+				filepath.Join(root, "fixtures", "b.json"),
+				filepath.Join(root, "d.json"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewServer(test.path)
+			if err != nil {
+				t.Fatal("cannot create server:", err)
+			}
+			if s.Dir() != test.dir {
+				t.Errorf("dir = %q, want %q", s.Dir(), test.dir)
+			}
+			if files := s.Files(); !reflect.DeepEqual(files, test.want) {
+				t.Errorf("files = %q, want %q", files, test.want)
+			}
+		})
+	}
+}
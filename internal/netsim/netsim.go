@@ -0,0 +1,313 @@
+// Package netsim simulates bad network conditions, such as latency, low
+// bandwidth and failing requests.
+package netsim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"libdb.so/saq/internal/proxy"
+)
+
+// Path is the path that the runtime controls are served at.
+const Path = "/__saq/netsim"
+
+// Rule is a set of network conditions applied to requests matching Pattern.
+type Rule struct {
+	// Pattern is matched against the request path using proxy.MatchPath. An
+	// empty pattern matches everything.
+	Pattern string `json:"pattern,omitempty"`
+	// Latency is added before the request is handled.
+	Latency Duration `json:"latency,omitempty"`
+	// Bandwidth limits the response to this many bytes per second. Zero means
+	// unlimited.
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+	// FailRate is the chance, from 0 to 1, that the request fails.
+	FailRate float64 `json:"failRate,omitempty"`
+	// Status is the status code that failed requests get. If zero, then the
+	// connection is dropped instead.
+	Status int `json:"status,omitempty"`
+}
+
+// Duration is a time.Duration that is a string in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ParseRule parses a rule in the form of
+//
+//	[/pattern:]latency=200ms,bandwidth=50k,fail=0.1,status=503
+//
+// where every field is optional. The conditions never have a colon, so the
+// pattern may have some. A status without a fail rate fails every request.
+func ParseRule(s string) (Rule, error) {
+	var rule Rule
+	var hasFail bool
+
+	if strings.HasPrefix(s, "/") {
+		i := strings.LastIndex(s, ":")
+		if i == -1 {
+			return rule, fmt.Errorf("rule %q has a pattern but no conditions", s)
+		}
+		rule.Pattern = s[:i]
+		s = s[i+1:]
+	}
+
+	for _, field := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return rule, fmt.Errorf("condition %q is missing =", field)
+		}
+
+		var err error
+		switch key {
+		case "latency":
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			rule.Latency = Duration(d)
+		case "bandwidth":
+			rule.Bandwidth, err = parseBytes(value)
+		case "fail":
+			rule.FailRate, err = strconv.ParseFloat(value, 64)
+			hasFail = true
+		case "status":
+			rule.Status, err = strconv.Atoi(value)
+		default:
+			return rule, fmt.Errorf("unknown condition %q", key)
+		}
+		if err != nil {
+			return rule, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	if rule.Status != 0 && !hasFail {
+		rule.FailRate = 1
+	}
+
+	return rule, rule.validate()
+}
+
+func (r Rule) validate() error {
+	if r.Pattern != "" {
+		if err := proxy.CheckPathPattern(r.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", r.Pattern, err)
+		}
+	}
+	if r.FailRate < 0 || r.FailRate > 1 {
+		return fmt.Errorf("fail rate %v is not between 0 and 1", r.FailRate)
+	}
+	if r.Status != 0 && (r.Status < 200 || r.Status > 599) {
+		return fmt.Errorf("status %d is not between 200 and 599", r.Status)
+	}
+	if r.Bandwidth < 0 || r.Latency < 0 {
+		return fmt.Errorf("bandwidth and latency cannot be negative")
+	}
+	return nil
+}
+
+// parseBytes parses a byte count with an optional k or m suffix.
+func parseBytes(s string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1 << 10
+		s = strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		multiplier = 1 << 20
+		s = strings.TrimSuffix(s, "m")
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// Simulator applies rules to requests. The first rule that matches a request
+// is used.
+type Simulator struct {
+	mu     sync.RWMutex
+	rules  []Rule
+	logger *slog.Logger
+}
+
+// NewSimulator creates a new simulator with the given rules.
+func NewSimulator(rules []Rule) *Simulator {
+	return &Simulator{
+		rules:  rules,
+		logger: slog.With("component", "netsim"),
+	}
+}
+
+// Rules returns the current rules.
+func (s *Simulator) Rules() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Rule(nil), s.rules...)
+}
+
+// SetRules replaces the current rules.
+func (s *Simulator) SetRules(rules []Rule) error {
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules = rules
+	return nil
+}
+
+func (s *Simulator) match(path string) (Rule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rule := range s.rules {
+		if rule.Pattern == "" || proxy.MatchPath(rule.Pattern, path) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Wrap wraps the handler so that the rules apply to its requests.
+func (s *Simulator) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := s.match(r.URL.Path)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if rule.Latency > 0 {
+			timer := time.NewTimer(time.Duration(rule.Latency))
+			select {
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		if rule.FailRate > 0 && rand.Float64() < rule.FailRate {
+			if rule.Status == 0 {
+				s.logger.Debug("dropping request", "path", r.URL.Path)
+				panic(http.ErrAbortHandler)
+			}
+			s.logger.Debug("failing request", "path", r.URL.Path, "status", rule.Status)
+			http.Error(w, "failed by saq's network simulation", rule.Status)
+			return
+		}
+
+		if rule.Bandwidth > 0 {
+			w = &throttledWriter{ResponseWriter: w, ctx: r.Context(), bandwidth: rule.Bandwidth}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Handler returns the handler for the runtime controls. GET returns the
+// current rules as JSON, and PUT replaces them.
+func (s *Simulator) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var rules []Rule
+			if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+				http.Error(w, "invalid rules: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := s.SetRules(rules); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Rules())
+	})
+}
+
+// throttledWriter writes at most bandwidth bytes per second. It stops once
+// ctx is done, which happens when the client goes away.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx       context.Context
+	bandwidth int64
+}
+
+// throttleInterval is how often a chunk is written out.
+const throttleInterval = 100 * time.Millisecond
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	chunk := int(w.bandwidth * int64(throttleInterval) / int64(time.Second))
+	if chunk < 1 {
+		chunk = 1
+	}
+
+	var written int
+	for len(b) > 0 {
+		n := chunk
+		if n > len(b) {
+			n = len(b)
+		}
+
+		n, err := w.ResponseWriter.Write(b[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		// Flush so that the client actually sees the bytes trickle in.
+		http.NewResponseController(w.ResponseWriter).Flush()
+
+		timer := time.NewTimer(time.Duration(int64(n) * int64(time.Second) / w.bandwidth))
+		select {
+		case <-w.ctx.Done():
+			timer.Stop()
+			return written, w.ctx.Err()
+		case <-timer.C:
+		}
+		b = b[n:]
+	}
+
+	return written, nil
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,213 @@
+package netsim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rule
+		wantErr bool
+	}{
+		{
+			in:   "latency=200ms",
+			want: Rule{Latency: Duration(200 * time.Millisecond)},
+		},
+		{
+			in: "/api/**:latency=1s,bandwidth=50k,fail=0.1,status=503",
+			want: Rule{
+				Pattern:   "/api/**",
+				Latency:   Duration(time.Second),
+				Bandwidth: 50 << 10,
+				FailRate:  0.1,
+				Status:    503,
+			},
+		},
+		{
+			in:   "/assets/**: bandwidth=2m",
+			want: Rule{Pattern: "/assets/**", Bandwidth: 2 << 20},
+		},
+		{
+			in:   "/users/*:fail=1",
+			want: Rule{Pattern: "/users/*", FailRate: 1},
+		},
+		{
+			in:   "/a:b/c:status=200",
+			want: Rule{Pattern: "/a:b/c", FailRate: 1, Status: 200},
+		},
+		{
+			in:   "status=503",
+			want: Rule{FailRate: 1, Status: 503},
+		},
+		{
+			in:   "fail=0,status=503",
+			want: Rule{Status: 503},
+		},
+		{in: "/api/**", wantErr: true},
+		{in: "/api/**:", wantErr: true},
+		{in: "latency", wantErr: true},
+		{in: "jitter=1s", wantErr: true},
+		{in: "latency=soon", wantErr: true},
+		{in: "latency=-1s", wantErr: true},
+		{in: "bandwidth=lots", wantErr: true},
+		{in: "fail=2", wantErr: true},
+		{in: "status=99", wantErr: true},
+		{in: "status=101", wantErr: true},
+		{in: "status=199", wantErr: true},
+		{in: "status=600", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			rule, err := ParseRule(test.in)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if rule != test.want {
+				t.Errorf("rule = %+v, want %+v", rule, test.want)
+			}
+		})
+	}
+}
+
+func TestSimulatorWrap(t *testing.T) {
+	const body = "0123456789"
+
+	tests := []struct {
+		name   string
+		rules  []Rule
+		path   string
+		status int
+		body   string
+		// dropped is true if the connection should be dropped.
+		dropped bool
+		// minTime is how long the request should take at least.
+		minTime time.Duration
+	}{
+		{
+			name:   "no match",
+			rules:  []Rule{{Pattern: "/api/**", FailRate: 1}},
+			path:   "/index.html",
+			status: 200,
+			body:   body,
+		},
+		{
+			name:    "latency",
+			rules:   []Rule{{Latency: Duration(50 * time.Millisecond)}},
+			path:    "/",
+			status:  200,
+			body:    body,
+			minTime: 50 * time.Millisecond,
+		},
+		{
+			name:   "status",
+			rules:  []Rule{{Pattern: "/api/**", FailRate: 1, Status: 503}},
+			path:   "/api/users",
+			status: 503,
+			body:   "failed by saq's network simulation\n",
+		},
+		{
+			name:    "drop",
+			rules:   []Rule{{FailRate: 1}},
+			path:    "/",
+			dropped: true,
+		},
+		{
+			name: "first match wins",
+			rules: []Rule{
+				{Pattern: "/api/**"},
+				{FailRate: 1, Status: 500},
+			},
+			path:   "/api/users",
+			status: 200,
+			body:   body,
+		},
+		{
+			// 40 bytes a second is written in chunks of 4 bytes every 100ms,
+			// so the 10 bytes take 3 chunks.
+			name:    "bandwidth",
+			rules:   []Rule{{Bandwidth: 40}},
+			path:    "/",
+			status:  200,
+			body:    body,
+			minTime: 200 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := NewSimulator(test.rules).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, body)
+			}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", test.path, nil)
+
+			var dropped bool
+			start := time.Now()
+			func() {
+				defer func() {
+					if v := recover(); v != nil {
+						if v != http.ErrAbortHandler {
+							panic(v)
+						}
+						dropped = true
+					}
+				}()
+				h.ServeHTTP(w, r)
+			}()
+			elapsed := time.Since(start)
+
+			if dropped != test.dropped {
+				t.Fatalf("dropped = %v, want %v", dropped, test.dropped)
+			}
+			if test.dropped {
+				return
+			}
+
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+			if got := w.Body.String(); got != test.body {
+				t.Errorf("body = %q, want %q", got, test.body)
+			}
+			if elapsed < test.minTime {
+				t.Errorf("took %v, want at least %v", elapsed, test.minTime)
+			}
+		})
+	}
+}
+
+func TestThrottledWriterCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	rec := httptest.NewRecorder()
+	w := &throttledWriter{ResponseWriter: rec, ctx: ctx, bandwidth: 10}
+
+	start := time.Now()
+	n, err := w.Write([]byte(strings.Repeat("x", 100)))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if n != 1 {
+		t.Errorf("wrote %d bytes, want 1", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("kept writing for %v after the client went away", elapsed)
+	}
+}
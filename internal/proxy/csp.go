@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CSPMode determines how the injected script gets past the page's
+// Content-Security-Policy.
+type CSPMode int
+
+const (
+	// CSPAuto reuses the page's nonce if it has one, otherwise it adds the
+	// script's hash to the policy.
+	CSPAuto CSPMode = iota
+	// CSPNonce only reuses the page's nonce and never rewrites the policy. The
+	// script is blocked if the page has no nonce.
+	CSPNonce
+	// CSPHash always adds the script's hash to the policy.
+	CSPHash
+	// CSPExternal injects the script as an external ClientScriptPath script
+	// and allows it in the policy.
+	CSPExternal
+)
+
+// ClientScriptPath is the path that the external script is served at when
+// CSPExternal is used.
+const ClientScriptPath = "/__saq/client.js"
+
+// ParseCSPMode parses a CSPMode from its String form.
+func ParseCSPMode(s string) (CSPMode, error) {
+	for _, mode := range []CSPMode{CSPAuto, CSPNonce, CSPHash, CSPExternal} {
+		if mode.String() == s {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown CSP mode %q", s)
+}
+
+func (m CSPMode) String() string {
+	switch m {
+	case CSPAuto:
+		return "auto"
+	case CSPNonce:
+		return "nonce"
+	case CSPHash:
+		return "hash"
+	case CSPExternal:
+		return "external"
+	default:
+		return fmt.Sprintf("CSPMode(%d)", int(m))
+	}
+}
+
+// cspHeader is the only header that we care about. Report-only policies don't
+// block anything.
+const cspHeader = "Content-Security-Policy"
+
+// applyCSP prepares the script tag so that it's allowed by the policies in h,
+// rewriting them if the mode permits. It returns the rewritten policies, or nil
+// if none were rewritten.
+func applyCSP(h http.Header, mode CSPMode, script string) (tag scriptTag, rewritten []string) {
+	tag = scriptTag{Content: script}
+	if mode == CSPExternal {
+		tag = scriptTag{Src: ClientScriptPath}
+	}
+
+	values := h.Values(cspHeader)
+	if len(values) == 0 {
+		return tag, nil
+	}
+
+	var policies []cspPolicy
+	for _, value := range values {
+		// A comma separates multiple policies in one header.
+		for _, policy := range strings.Split(value, ",") {
+			policies = append(policies, parseCSP(policy))
+		}
+	}
+
+	var restricted []cspPolicy
+	for _, policy := range policies {
+		if d := policy.scriptDirective(); d != nil && !d.allows(tag) {
+			restricted = append(restricted, policy)
+		}
+	}
+
+	if nonce, ok := commonNonce(restricted); ok && mode != CSPHash {
+		tag.Nonce = nonce
+		restricted = nil
+	}
+
+	changed := make([]bool, len(policies))
+
+	if len(restricted) > 0 && mode != CSPNonce {
+		var source string
+		if tag.Src != "" {
+			source = "'self'"
+		} else {
+			sum := sha256.Sum256([]byte(tag.Content))
+			source = "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+		}
+
+		for i, policy := range policies {
+			if d := policy.scriptDirective(); d != nil && !d.allows(tag) {
+				d.add(source)
+				changed[i] = true
+			}
+		}
+	}
+
+	// The script also needs to be able to fetch from us.
+	if mode != CSPNonce {
+		for i, policy := range policies {
+			if d := policy.lookup("connect-src", "default-src"); d != nil && !d.hasAny("'self'", "*") {
+				d.add("'self'")
+				changed[i] = true
+			}
+		}
+	}
+
+	var anyChanged bool
+	for _, c := range changed {
+		anyChanged = anyChanged || c
+	}
+	if !anyChanged {
+		return tag, nil
+	}
+
+	rewritten = make([]string, len(policies))
+	for i, policy := range policies {
+		rewritten[i] = policy.String()
+	}
+
+	h.Set(cspHeader, strings.Join(rewritten, ", "))
+
+	return tag, rewritten
+}
+
+// commonNonce returns the nonce that is present in the script directive of all
+// given policies.
+func commonNonce(policies []cspPolicy) (string, bool) {
+	if len(policies) == 0 {
+		return "", false
+	}
+
+	nonce, ok := policies[0].scriptDirective().nonce()
+	if !ok {
+		return "", false
+	}
+
+	for _, policy := range policies[1:] {
+		if !policy.scriptDirective().has("'nonce-" + nonce + "'") {
+			return "", false
+		}
+	}
+
+	return nonce, true
+}
+
+type cspPolicy []*cspDirective
+
+type cspDirective struct {
+	name   string
+	values []string
+}
+
+func parseCSP(s string) cspPolicy {
+	var policy cspPolicy
+	for _, directive := range strings.Split(s, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		policy = append(policy, &cspDirective{
+			name:   strings.ToLower(fields[0]),
+			values: fields[1:],
+		})
+	}
+	return policy
+}
+
+func (p cspPolicy) String() string {
+	directives := make([]string, len(p))
+	for i, d := range p {
+		directives[i] = strings.Join(append([]string{d.name}, d.values...), " ")
+	}
+	return strings.Join(directives, "; ")
+}
+
+// lookup returns the first directive in names that exists in the policy. The
+// names should be given from the most to the least specific.
+func (p cspPolicy) lookup(names ...string) *cspDirective {
+	for _, name := range names {
+		for _, d := range p {
+			if d.name == name {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// scriptDirective returns the directive that governs <script> elements.
+func (p cspPolicy) scriptDirective() *cspDirective {
+	return p.lookup("script-src-elem", "script-src", "default-src")
+}
+
+func (d *cspDirective) has(value string) bool {
+	return d.hasAny(value)
+}
+
+func (d *cspDirective) hasAny(values ...string) bool {
+	for _, v := range d.values {
+		for _, value := range values {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// add adds a source to the directive. 'none' must be the only source to be
+// valid, so it's replaced.
+func (d *cspDirective) add(source string) {
+	values := d.values[:0]
+	for _, v := range d.values {
+		if !strings.EqualFold(v, "'none'") {
+			values = append(values, v)
+		}
+	}
+	d.values = append(values, source)
+}
+
+func (d *cspDirective) nonce() (string, bool) {
+	for _, v := range d.values {
+		if strings.HasPrefix(v, "'nonce-") && strings.HasSuffix(v, "'") {
+			return strings.TrimSuffix(strings.TrimPrefix(v, "'nonce-"), "'"), true
+		}
+	}
+	return "", false
+}
+
+// allows returns true if the directive already allows the given tag.
+func (d *cspDirective) allows(tag scriptTag) bool {
+	if tag.Nonce != "" && d.has("'nonce-"+tag.Nonce+"'") {
+		return true
+	}
+
+	// 'strict-dynamic' makes browsers ignore both 'unsafe-inline' and host
+	// sources, while any nonce or hash makes them ignore 'unsafe-inline'.
+	if d.has("'strict-dynamic'") {
+		return false
+	}
+
+	if tag.Src != "" {
+		return d.hasAny("'self'", "*")
+	}
+
+	for _, v := range d.values {
+		if strings.HasPrefix(v, "'nonce-") || strings.HasPrefix(v, "'sha") {
+			return false
+		}
+	}
+
+	return d.has("'unsafe-inline'")
+}
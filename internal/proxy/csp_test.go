@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestApplyCSP(t *testing.T) {
+	const script = `console.log("hi")`
+
+	sum := sha256.Sum256([]byte(script))
+	hash := "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+
+	tests := []struct {
+		name   string
+		mode   CSPMode
+		policy string
+		want   string // empty if the policy must not be rewritten
+		nonce  string
+	}{
+		{
+			name: "no policy",
+			mode: CSPAuto,
+		},
+		{
+			name:   "unsafe-inline",
+			mode:   CSPAuto,
+			policy: "script-src 'self' 'unsafe-inline'",
+		},
+		{
+			name:   "nonce",
+			mode:   CSPAuto,
+			policy: "script-src 'nonce-abc'",
+			nonce:  "abc",
+		},
+		{
+			name:   "hash",
+			mode:   CSPAuto,
+			policy: "script-src 'self'",
+			want:   "script-src 'self' " + hash,
+		},
+		{
+			name:   "hash mode ignores nonce",
+			mode:   CSPHash,
+			policy: "script-src 'nonce-abc'",
+			want:   "script-src 'nonce-abc' " + hash,
+		},
+		{
+			name:   "nonce mode never rewrites",
+			mode:   CSPNonce,
+			policy: "script-src 'self'; connect-src 'none'",
+		},
+		{
+			name:   "strict-dynamic ignores unsafe-inline",
+			mode:   CSPAuto,
+			policy: "script-src 'unsafe-inline' 'strict-dynamic'",
+			want:   "script-src 'unsafe-inline' 'strict-dynamic' " + hash,
+		},
+		{
+			name:   "script-src none",
+			mode:   CSPAuto,
+			policy: "script-src 'none'",
+			want:   "script-src " + hash,
+		},
+		{
+			name:   "default-src none",
+			mode:   CSPAuto,
+			policy: "default-src 'none'",
+			want:   "default-src " + hash + " 'self'",
+		},
+		{
+			name:   "connect-src none",
+			mode:   CSPAuto,
+			policy: "script-src 'unsafe-inline'; connect-src 'none'",
+			want:   "script-src 'unsafe-inline'; connect-src 'self'",
+		},
+		{
+			name:   "connect-src wildcard",
+			mode:   CSPAuto,
+			policy: "script-src 'unsafe-inline'; connect-src *",
+		},
+		{
+			name:   "external",
+			mode:   CSPExternal,
+			policy: "script-src 'none'; connect-src 'none'",
+			want:   "script-src 'self'; connect-src 'self'",
+		},
+		{
+			name:   "multiple policies",
+			mode:   CSPAuto,
+			policy: "script-src 'unsafe-inline', default-src 'none'",
+			want:   "script-src 'unsafe-inline', default-src " + hash + " 'self'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := http.Header{}
+			if test.policy != "" {
+				h.Set(cspHeader, test.policy)
+			}
+
+			tag, rewritten := applyCSP(h, test.mode, script)
+
+			if test.want == "" {
+				if rewritten != nil {
+					t.Errorf("policy was rewritten to %q", strings.Join(rewritten, ", "))
+				}
+				if got := h.Get(cspHeader); got != test.policy {
+					t.Errorf("header = %q, want it untouched", got)
+				}
+			} else {
+				if got := h.Get(cspHeader); got != test.want {
+					t.Errorf("header = %q, want %q", got, test.want)
+				}
+				if got := strings.Join(rewritten, ", "); got != test.want {
+					t.Errorf("rewritten = %q, want %q", got, test.want)
+				}
+			}
+
+			if tag.Nonce != test.nonce {
+				t.Errorf("nonce = %q, want %q", tag.Nonce, test.nonce)
+			}
+			if test.mode == CSPExternal {
+				if tag.Src != ClientScriptPath || tag.Content != "" {
+					t.Errorf("tag = %+v, want an external script", tag)
+				}
+			} else if tag.Content != script {
+				t.Errorf("tag = %+v, want an inline script", tag)
+			}
+		})
+	}
+}
+
+func TestParseCSPMode(t *testing.T) {
+	for _, mode := range []CSPMode{CSPAuto, CSPNonce, CSPHash, CSPExternal} {
+		got, err := ParseCSPMode(mode.String())
+		if err != nil || got != mode {
+			t.Errorf("ParseCSPMode(%q) = %v, %v", mode, got, err)
+		}
+	}
+
+	if _, err := ParseCSPMode("bogus"); err == nil {
+		t.Error("expected error for an unknown mode")
+	}
+}
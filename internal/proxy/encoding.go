@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// contentEncodings returns the list of encodings in the Content-Encoding
+// header in the order that they were applied.
+func contentEncodings(h http.Header) []string {
+	var encodings []string
+	for _, value := range h.Values("Content-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+	return encodings
+}
+
+// decodeBody undoes the given encodings on the body.
+func decodeBody(encodings []string, body []byte) ([]byte, error) {
+	for i := len(encodings) - 1; i >= 0; i-- {
+		r, err := newDecoder(encodings[i], bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		body, err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", encodings[i], err)
+		}
+	}
+	return body, nil
+}
+
+// encodeBody applies the given encodings on the body.
+func encodeBody(encodings []string, body []byte) ([]byte, error) {
+	for _, encoding := range encodings {
+		var buf bytes.Buffer
+
+		w, err := newEncoder(encoding, &buf)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := w.Write(body); err != nil {
+			return nil, fmt.Errorf("failed to compress %s: %w", encoding, err)
+		}
+
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("failed to flush %s compressor: %w", encoding, err)
+		}
+
+		body = buf.Bytes()
+	}
+	return body, nil
+}
+
+func newDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		gzipR, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return gzipR, nil
+	case "deflate":
+		// HTTP's deflate is zlib-wrapped, not raw DEFLATE.
+		zlibR, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zlib reader: %w", err)
+		}
+		return zlibR, nil
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		zstdR, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		return zstdR.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+func newEncoder(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewWriter(w), nil
+	case "deflate":
+		return zlib.NewWriter(w), nil
+	case "br":
+		return brotli.NewWriter(w), nil
+	case "zstd":
+		zstdW, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		return zstdW, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+// DecodeBody undoes the Content-Encoding in h on the body.
+func DecodeBody(h http.Header, body []byte) ([]byte, error) {
+	return decodeBody(contentEncodings(h), body)
+}
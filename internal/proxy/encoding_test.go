@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/zlib"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestContentEncodings(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{nil, nil},
+		{[]string{"identity"}, nil},
+		{[]string{"gzip"}, []string{"gzip"}},
+		{[]string{"Deflate, BR"}, []string{"deflate", "br"}},
+		{[]string{"gzip", "identity", "zstd"}, []string{"gzip", "zstd"}},
+	}
+
+	for _, test := range tests {
+		h := http.Header{"Content-Encoding": test.values}
+		if got := contentEncodings(h); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: encodings = %q, want %q", test.values, got, test.want)
+		}
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte("<p>hello, world</p>\n"), 100)
+
+	tests := []struct {
+		name      string
+		encodings []string
+	}{
+		{"identity", nil},
+		{"gzip", []string{"gzip"}},
+		{"x-gzip", []string{"x-gzip"}},
+		{"deflate", []string{"deflate"}},
+		{"br", []string{"br"}},
+		{"zstd", []string{"zstd"}},
+		{"stacked", []string{"deflate", "br"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := encodeBody(test.encodings, body)
+			if err != nil {
+				t.Fatal("cannot encode:", err)
+			}
+
+			if len(test.encodings) > 0 && bytes.Equal(encoded, body) {
+				t.Error("body was not encoded")
+			}
+
+			decoded, err := decodeBody(test.encodings, encoded)
+			if err != nil {
+				t.Fatal("cannot decode:", err)
+			}
+
+			if !bytes.Equal(decoded, body) {
+				t.Errorf("decoded body = %q, want %q", decoded, body)
+			}
+		})
+	}
+}
+
+func TestDecodeDeflateIsZlib(t *testing.T) {
+	body := []byte("<p>hello</p>")
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(body)
+	w.Close()
+
+	decoded, err := decodeBody([]string{"deflate"}, buf.Bytes())
+	if err != nil {
+		t.Fatal("cannot decode:", err)
+	}
+	if !bytes.Equal(decoded, body) {
+		t.Errorf("decoded body = %q, want %q", decoded, body)
+	}
+}
+
+func TestDecodeBodyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		encodings []string
+		body      string
+	}{
+		{"unknown", []string{"compress"}, "whatever"},
+		{"corrupt gzip", []string{"gzip"}, "not gzip"},
+		{"corrupt deflate", []string{"deflate"}, "not zlib"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeBody(test.encodings, []byte(test.body)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
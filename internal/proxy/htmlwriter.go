@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"bytes"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// HTMLMutator mutates an HTML response body. The response headers may also be
+// changed, since they're only written out after the mutator returns.
+type HTMLMutator func(h http.Header, body []byte) []byte
+
+// maybeMutateHTML buffers the response if it's HTML so that it can be mutated
+// once the upstream is done writing. Other responses are written through.
+// HTML responses therefore aren't streamed, and the client sees nothing of
+// them until the upstream finishes.
+type maybeMutateHTML struct {
+	http.ResponseWriter
+	buffer bytes.Buffer
+	code   int
+	isHTML int
+	logger *slog.Logger
+}
+
+var (
+	_ http.ResponseWriter = (*maybeMutateHTML)(nil)
+	_ http.Flusher        = (*maybeMutateHTML)(nil)
+)
+
+func (m *maybeMutateHTML) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints come before the final
+	// one, so they're passed through as they are.
+	if code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols {
+		m.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	if m.isHTML != 0 || m.code != 0 {
+		return
+	}
+
+	m.code = code
+
+	// Without a Content-Type, we'll have to wait for the body to sniff it.
+	// Compressed bodies can't be sniffed, so those are never HTML.
+	contentType := m.Header().Get("Content-Type")
+	if contentType != "" || len(contentEncodings(m.Header())) > 0 {
+		m.decide(contentType)
+	}
+}
+
+func (m *maybeMutateHTML) Write(b []byte) (int, error) {
+	if m.code == 0 {
+		m.WriteHeader(http.StatusOK)
+	}
+	if m.isHTML == 0 {
+		m.decide(http.DetectContentType(b))
+	}
+	switch m.isHTML {
+	case 1:
+		return m.buffer.Write(b)
+	case -1:
+		return m.ResponseWriter.Write(b)
+	default:
+		panic("unreachable")
+	}
+}
+
+func (m *maybeMutateHTML) decide(contentType string) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "text/html" {
+		m.isHTML = 1
+	} else {
+		m.isHTML = -1
+		m.ResponseWriter.WriteHeader(m.code)
+	}
+}
+
+// Flush flushes the response if it's not being buffered.
+func (m *maybeMutateHTML) Flush() {
+	if m.isHTML == -1 {
+		http.NewResponseController(m.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (m *maybeMutateHTML) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
+
+// apply applies the mutator onto the buffered HTML and writes everything out.
+// It does nothing if the response wasn't HTML. If the body cannot be decoded,
+// then it is written out untouched.
+func (m *maybeMutateHTML) apply(mutate HTMLMutator) error {
+	if m.isHTML == 0 && m.code != 0 {
+		// There was no body to sniff.
+		m.ResponseWriter.WriteHeader(m.code)
+		return nil
+	}
+
+	if m.isHTML != 1 {
+		return nil
+	}
+
+	h := m.Header()
+
+	// Don't touch empty bodies, since they're probably meant to stay that way,
+	// like a 304.
+	if m.buffer.Len() == 0 {
+		m.ResponseWriter.WriteHeader(m.code)
+		return nil
+	}
+
+	body, err := m.mutate(h, mutate)
+	if err != nil {
+		m.logger.Warn("cannot mutate HTML, passing it through as-is",
+			"encoding", h.Values("Content-Encoding"),
+			"err", err)
+		body = m.buffer.Bytes()
+	} else {
+		// The body is different now, so these no longer describe it.
+		h.Del("ETag")
+		h.Del("Content-MD5")
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	m.ResponseWriter.WriteHeader(m.code)
+
+	_, err = m.ResponseWriter.Write(body)
+	return err
+}
+
+// mutate decodes the buffered body, mutates it and encodes it back.
+func (m *maybeMutateHTML) mutate(h http.Header, mutate HTMLMutator) ([]byte, error) {
+	// Whatever the upstream compressed the body with was negotiated with the
+	// browser, so we use the same encodings when writing it back.
+	encodings := contentEncodings(h)
+
+	body, err := decodeBody(encodings, m.buffer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	body = mutate(h, body)
+
+	return encodeBody(encodings, body)
+}
+
+// discardBody drops the response body, such as for a HEAD request that was
+// sent to the upstream as a GET.
+type discardBody struct {
+	http.ResponseWriter
+}
+
+func (w discardBody) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (w discardBody) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordingWriter records every status code that is written, unlike
+// httptest.ResponseRecorder, which only keeps the first one.
+type recordingWriter struct {
+	header http.Header
+	codes  []int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	w.codes = append(w.codes, code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func TestMaybeMutateHTML(t *testing.T) {
+	const html = "<html><body></body></html>"
+	const mutated = "<html><body>hi</body></html>"
+
+	gzipped, err := encodeBody([]string{"gzip"}, []byte(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type response struct {
+		header http.Header
+		codes  []int
+		body   string
+	}
+
+	tests := []struct {
+		name string
+		in   response
+		want response
+	}{
+		{
+			name: "html",
+			in: response{
+				header: http.Header{"Content-Type": {"text/html; charset=utf-8"}, "Etag": {`"1"`}},
+				codes:  []int{200},
+				body:   html,
+			},
+			want: response{
+				header: http.Header{"Content-Type": {"text/html; charset=utf-8"}, "Content-Length": {"28"}},
+				codes:  []int{200},
+				body:   mutated,
+			},
+		},
+		{
+			name: "not html",
+			in: response{
+				header: http.Header{"Content-Type": {"text/plain"}},
+				codes:  []int{200},
+				body:   html,
+			},
+			want: response{
+				header: http.Header{"Content-Type": {"text/plain"}},
+				codes:  []int{200},
+				body:   html,
+			},
+		},
+		{
+			name: "sniffed html",
+			in: response{
+				header: http.Header{},
+				body:   html,
+			},
+			want: response{
+				header: http.Header{"Content-Length": {"28"}},
+				codes:  []int{200},
+				body:   mutated,
+			},
+		},
+		{
+			name: "early hints",
+			in: response{
+				header: http.Header{"Content-Type": {"text/html"}},
+				codes:  []int{103, 200},
+				body:   html,
+			},
+			want: response{
+				header: http.Header{"Content-Type": {"text/html"}, "Content-Length": {"28"}},
+				codes:  []int{103, 200},
+				body:   mutated,
+			},
+		},
+		{
+			name: "compressed without type",
+			in: response{
+				header: http.Header{"Content-Encoding": {"gzip"}},
+				codes:  []int{200},
+				body:   string(gzipped),
+			},
+			want: response{
+				header: http.Header{"Content-Encoding": {"gzip"}},
+				codes:  []int{200},
+				body:   string(gzipped),
+			},
+		},
+		{
+			name: "undecodable",
+			in: response{
+				header: http.Header{"Content-Type": {"text/html"}, "Content-Encoding": {"compress"}, "Etag": {`"1"`}},
+				codes:  []int{200},
+				body:   html,
+			},
+			want: response{
+				header: http.Header{"Content-Type": {"text/html"}, "Content-Encoding": {"compress"}, "Etag": {`"1"`}, "Content-Length": {"26"}},
+				codes:  []int{200},
+				body:   html,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &recordingWriter{header: test.in.header}
+			m := &maybeMutateHTML{ResponseWriter: w, logger: slog.Default()}
+
+			for _, code := range test.in.codes {
+				m.WriteHeader(code)
+			}
+			m.Write([]byte(test.in.body))
+
+			err := m.apply(func(h http.Header, body []byte) []byte {
+				return []byte(strings.Replace(string(body), "<body>", "<body>hi", 1))
+			})
+			if err != nil {
+				t.Fatal("cannot apply:", err)
+			}
+
+			if !reflect.DeepEqual(w.codes, test.want.codes) {
+				t.Errorf("codes = %v, want %v", w.codes, test.want.codes)
+			}
+			if !reflect.DeepEqual(w.header, test.want.header) {
+				t.Errorf("header = %v, want %v", w.header, test.want.header)
+			}
+			if got := w.body.String(); got != test.want.body {
+				t.Errorf("body = %q, want %q", got, test.want.body)
+			}
+		})
+	}
+}
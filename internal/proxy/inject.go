@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	htmltok "golang.org/x/net/html"
+)
+
+// scriptTag is a <script> element.
+type scriptTag struct {
+	Src     string
+	Nonce   string
+	Content string
+}
+
+func (t scriptTag) render(module bool) string {
+	var b strings.Builder
+	b.WriteString("<script")
+	if module {
+		b.WriteString(` type="module"`)
+	}
+	if t.Src != "" {
+		fmt.Fprintf(&b, ` src="%s"`, html.EscapeString(t.Src))
+	}
+	if t.Nonce != "" {
+		fmt.Fprintf(&b, ` nonce="%s"`, html.EscapeString(t.Nonce))
+	}
+	b.WriteString(">")
+	b.WriteString(t.Content)
+	b.WriteString("</script>")
+	return b.String()
+}
+
+// injectScript injects the script tag into the HTML document. The script
+// is placed right before the closing </body> tag. If there is no body, then
+// it is placed at the end of <head> as a module script, which is deferred
+// until the document is parsed. Fragments get the script appended at the end,
+// except for fragments that only contain <template> elements, which are
+// returned as-is.
+func injectScript(body []byte, tag scriptTag) []byte {
+	marks := scanHTML(body)
+
+	switch {
+	case marks.empty || marks.templateOnly:
+		return body
+	case marks.bodyEnd != -1:
+		return insertAt(body, marks.bodyEnd, tag.render(false))
+	case marks.headEnd != -1:
+		return insertAt(body, marks.headEnd, tag.render(true))
+	case marks.htmlEnd != -1:
+		return insertAt(body, marks.htmlEnd, tag.render(false))
+	default:
+		return append(body, tag.render(false)...)
+	}
+}
+
+func insertAt(body []byte, at int, s string) []byte {
+	out := make([]byte, 0, len(body)+len(s))
+	out = append(out, body[:at]...)
+	out = append(out, s...)
+	out = append(out, body[at:]...)
+	return out
+}
+
+type htmlLandmarks struct {
+	// headEnd, bodyEnd and htmlEnd are the byte offsets of the last </head>,
+	// </body> and </html> tags, or -1 if there are none.
+	headEnd int
+	bodyEnd int
+	htmlEnd int
+	// empty is true if the document has nothing but whitespace and comments.
+	empty bool
+	// templateOnly is true if the document only has <template> elements at
+	// its top level.
+	templateOnly bool
+}
+
+// scanHTML tokenizes the given HTML and finds where things are. Unlike a
+// regular expression, the tokenizer knows to skip over tags inside comments
+// and <script> blocks.
+func scanHTML(body []byte) htmlLandmarks {
+	marks := htmlLandmarks{
+		headEnd:      -1,
+		bodyEnd:      -1,
+		htmlEnd:      -1,
+		empty:        true,
+		templateOnly: true,
+	}
+
+	var offset int
+	var templateDepth int
+
+	z := htmltok.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == htmltok.ErrorToken {
+			if z.Err() != io.EOF {
+				// Not sure what this is, so don't treat it specially.
+				marks.templateOnly = false
+			}
+			break
+		}
+
+		start := offset
+		offset += len(z.Raw())
+
+		switch tt {
+		case htmltok.CommentToken:
+			continue
+		case htmltok.TextToken:
+			if templateDepth == 0 && len(bytes.TrimSpace(z.Text())) > 0 {
+				marks.empty = false
+				marks.templateOnly = false
+			}
+			continue
+		case htmltok.DoctypeToken:
+			marks.empty = false
+			marks.templateOnly = false
+			continue
+		}
+
+		marks.empty = false
+
+		name, _ := z.TagName()
+		switch tt {
+		case htmltok.StartTagToken:
+			if string(name) == "template" {
+				templateDepth++
+			} else if templateDepth == 0 {
+				marks.templateOnly = false
+			}
+		case htmltok.SelfClosingTagToken:
+			if templateDepth == 0 {
+				marks.templateOnly = false
+			}
+		case htmltok.EndTagToken:
+			if string(name) == "template" {
+				if templateDepth > 0 {
+					templateDepth--
+				}
+				break
+			}
+			if templateDepth > 0 {
+				break
+			}
+			switch string(name) {
+			case "head":
+				marks.headEnd = start
+			case "body":
+				marks.bodyEnd = start
+			case "html":
+				marks.htmlEnd = start
+			}
+		}
+	}
+
+	return marks
+}
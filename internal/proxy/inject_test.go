@@ -0,0 +1,158 @@
+package proxy
+
+import "testing"
+
+func TestScanHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want htmlLandmarks
+	}{
+		{
+			name: "document",
+			in:   "<html><head></head><body></body></html>",
+			want: htmlLandmarks{headEnd: 12, bodyEnd: 25, htmlEnd: 32},
+		},
+		{
+			name: "uppercase tags",
+			in:   "<HTML><HEAD></HEAD><BODY></BODY></HTML>",
+			want: htmlLandmarks{headEnd: 12, bodyEnd: 25, htmlEnd: 32},
+		},
+		{
+			name: "missing head",
+			in:   "<body></body>",
+			want: htmlLandmarks{headEnd: -1, bodyEnd: 6, htmlEnd: -1},
+		},
+		{
+			name: "missing body",
+			in:   "<head></head>",
+			want: htmlLandmarks{headEnd: 6, bodyEnd: -1, htmlEnd: -1},
+		},
+		{
+			name: "tags in comments",
+			in:   "<body><!-- </body> --></body>",
+			want: htmlLandmarks{headEnd: -1, bodyEnd: 22, htmlEnd: -1},
+		},
+		{
+			name: "tags in scripts",
+			in:   `<head><script>"</head>"</script></head>`,
+			want: htmlLandmarks{headEnd: 32, bodyEnd: -1, htmlEnd: -1},
+		},
+		{
+			name: "last tag",
+			in:   "<body></body><body></body>",
+			want: htmlLandmarks{headEnd: -1, bodyEnd: 19, htmlEnd: -1},
+		},
+		{
+			name: "empty",
+			in:   " <!-- nothing --> \n",
+			want: htmlLandmarks{headEnd: -1, bodyEnd: -1, htmlEnd: -1, empty: true, templateOnly: true},
+		},
+		{
+			name: "templates",
+			in:   "<template><body></body></template>\n<template><p></p></template>",
+			want: htmlLandmarks{headEnd: -1, bodyEnd: -1, htmlEnd: -1, templateOnly: true},
+		},
+		{
+			name: "fragment",
+			in:   "<template></template><p>hi</p>",
+			want: htmlLandmarks{headEnd: -1, bodyEnd: -1, htmlEnd: -1},
+		},
+		{
+			name: "text",
+			in:   "hi",
+			want: htmlLandmarks{headEnd: -1, bodyEnd: -1, htmlEnd: -1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := scanHTML([]byte(test.in)); got != test.want {
+				t.Errorf("landmarks = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInjectScript(t *testing.T) {
+	tag := scriptTag{Content: "x()"}
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "body",
+			in:   "<html><head></head><body><p>hi</p></body></html>",
+			want: "<html><head></head><body><p>hi</p><script>x()</script></body></html>",
+		},
+		{
+			name: "uppercase body",
+			in:   "<HTML><BODY></BODY></HTML>",
+			want: "<HTML><BODY><script>x()</script></BODY></HTML>",
+		},
+		{
+			name: "missing body",
+			in:   "<html><head><title>hi</title></head></html>",
+			want: `<html><head><title>hi</title><script type="module">x()</script></head></html>`,
+		},
+		{
+			name: "missing head and body",
+			in:   "<html><p>hi</p></html>",
+			want: "<html><p>hi</p><script>x()</script></html>",
+		},
+		{
+			name: "body in comment",
+			in:   "<body><p>hi</p><!-- </body> -->",
+			want: "<body><p>hi</p><!-- </body> --><script>x()</script>",
+		},
+		{
+			name: "body in script in head",
+			in:   `<head><script>document.write("</body>")</script></head>`,
+			want: `<head><script>document.write("</body>")</script><script type="module">x()</script></head>`,
+		},
+		{
+			name: "fragment",
+			in:   "<p>hi</p>",
+			want: "<p>hi</p><script>x()</script>",
+		},
+		{
+			name: "templates",
+			in:   "<template><p>hi</p></template>",
+			want: "<template><p>hi</p></template>",
+		},
+		{
+			name: "empty",
+			in:   "<!-- nothing -->",
+			want: "<!-- nothing -->",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(injectScript([]byte(test.in), tag)); got != test.want {
+				t.Errorf("got  %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScriptTagRender(t *testing.T) {
+	tests := []struct {
+		tag    scriptTag
+		module bool
+		want   string
+	}{
+		{scriptTag{Content: "x()"}, false, "<script>x()</script>"},
+		{scriptTag{Content: "x()"}, true, `<script type="module">x()</script>`},
+		{scriptTag{Src: "/__saq/a.js?b=1&c=2"}, false, `<script src="/__saq/a.js?b=1&amp;c=2"></script>`},
+		{scriptTag{Nonce: `"n"`, Content: "x()"}, false, `<script nonce="&#34;n&#34;">x()</script>`},
+	}
+
+	for _, test := range tests {
+		if got := test.tag.render(test.module); got != test.want {
+			t.Errorf("%+v.render(%v) = %q, want %q", test.tag, test.module, got, test.want)
+		}
+	}
+}
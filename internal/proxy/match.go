@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchPath returns true if the URL path matches the given pattern. Patterns
+// are matched using path.Match, except that a pattern ending in "/**" matches
+// everything under that directory, including the directory itself.
+func MatchPath(pattern, urlPath string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return urlPath == dir || strings.HasPrefix(urlPath, dir+"/")
+	}
+	match, _ := path.Match(pattern, urlPath)
+	return match
+}
+
+// CheckPathPattern returns an error if the pattern is malformed.
+func CheckPathPattern(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+func matchAnyPath(patterns []string, urlPath string) bool {
+	for _, pattern := range patterns {
+		if MatchPath(pattern, urlPath) {
+			return true
+		}
+	}
+	return false
+}
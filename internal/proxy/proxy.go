@@ -3,101 +3,182 @@
 package proxy
 
 import (
-	"fmt"
-	"html"
+	"context"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"path/filepath"
 	"strings"
-
-	"github.com/diamondburned/ghproxy/htmlmut"
-	"github.com/diamondburned/ghproxy/proxy"
+	"sync"
+	"time"
 )
 
-// writeBigError writes a big red HTML error. This will appear very ugly.
-func writeBigError(w io.Writer, err error) {
-	const tmpl = `<h1 class="proxy-error" style="color:red;font-family:monospace">%s</h1>`
-	fmt.Fprintf(w, tmpl, html.EscapeString(err.Error()))
+// Opts are options for the reverse proxy.
+type Opts struct {
+	// Transport is used to make requests to the upstream. If nil, then
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+	// Script is the JavaScript to inject into HTML pages. If empty, nothing is
+	// injected.
+	Script string
+	// CSPMode determines how Script gets past the page's
+	// Content-Security-Policy.
+	CSPMode CSPMode
+	// InjectInclude, if not empty, limits script injection to request paths
+	// that match any of these patterns. See MatchPath for the syntax.
+	InjectInclude []string
+	// InjectExclude prevents script injection for request paths that match any
+	// of these patterns. It takes precedence over InjectInclude.
+	InjectExclude []string
+	// Upstream, if not nil, is used to tell the user what's going on with the
+	// upstream when it cannot be reached.
+	Upstream Upstream
+	// HoldTimeout is how long to hold requests waiting for the Upstream to
+	// come back up before giving up. Zero disables holding.
+	HoldTimeout time.Duration
+	// Queue, if enabled, makes requests wait for the Upstream while it's down
+	// instead of failing. Unlike HoldTimeout, request bodies are buffered so
+	// that any request can be replayed.
+	Queue QueueOpts
 }
 
 type ReverseProxy struct {
 	*httputil.ReverseProxy
-	targetURL         *url.URL
-	htmlMutator       proxy.HTMLMutator
-	cookieInterceptor proxy.CookieInterceptor
+	opts      Opts
+	targetURL *url.URL
+	cspWarned sync.Map // map[string]struct{}
+	upgrades  upgradeTracker
+	queue     *requestQueue
+	logger    *slog.Logger
 }
 
-func NewReverseProxy(target url.URL, htmlMutator htmlmut.MutateFunc) *ReverseProxy {
-	domainHeader := fmt.Sprintf("Domain=%s; ", target.Hostname())
+func NewReverseProxy(target url.URL, opts Opts) *ReverseProxy {
 	targetURL := &target
+	logger := slog.With("component", "proxy", "target", targetURL.String())
 
-	if htmlMutator == nil {
-		htmlMutator = htmlmut.ChainMutators()
-	}
-
-	return &ReverseProxy{
+	rp := &ReverseProxy{
 		ReverseProxy: httputil.NewSingleHostReverseProxy(targetURL),
+		opts:         opts,
 		targetURL:    targetURL,
-		htmlMutator:  proxy.NewHTMLMutator(htmlMutator),
-		cookieInterceptor: proxy.NewCookieInterceptor(func(setCookie string) string {
-			return strings.ReplaceAll(setCookie, domainHeader, "")
-		}),
+		upgrades:     upgradeTracker{logger: logger},
+		queue:        newRequestQueue(opts.Queue, opts.Upstream, logger),
+		logger:       logger,
 	}
+	rp.ReverseProxy.Transport = opts.Transport
+	rp.ReverseProxy.ModifyResponse = rp.modifyResponse
+	rp.ReverseProxy.ErrorHandler = rp.handleError
+
+	return rp
 }
 
 // ServeHTTP serves the reverse proxy. If the request has a path that starts
 // with the previously given targetURL, the server will 301 redirect that to a
 // request with the path trimmed.
 func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch filepath.Ext(r.URL.Path) {
-	case ".html", "":
-		cWriter := rp.cookieInterceptor.NewWriter(w)
-		htmlMut := rp.htmlMutator.NewWriter(cWriter)
-		r.Host = rp.targetURL.Host
-		r.Header.Del("Accept-Encoding") // don't deal with compression
-
-		maybeMutate := &maybeMutateHTML{
-			HTMLMutatorWriter: htmlMut,
-			bypass:            cWriter,
-		}
+	if rp.opts.CSPMode == CSPExternal && r.URL.Path == ClientScriptPath {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, rp.opts.Script)
+		return
+	}
+
+	// Remember how the client reached us before pretending to be the
+	// upstream, so that URLs pointing to the upstream can be rewritten.
+	r = r.WithContext(withOrigin(r.Context(), requestOrigin(r)))
+	r.Host = rp.targetURL.Host
 
-		rp.ReverseProxy.ServeHTTP(maybeMutate, r)
+	// Upgrades can't go through the HTML mutator, since it's not a Hijacker.
+	if isUpgrade(r) {
+		rp.ReverseProxy.ServeHTTP(&upgradeWriter{
+			ResponseWriter: w,
+			tracker:        &rp.upgrades,
+			websocket:      isWebSocket(r),
+		}, r)
+		return
+	}
+
+	// A HEAD response has no body to mutate, so its Content-Length would be
+	// the upstream's instead of what a GET gets. The upstream is asked for
+	// the GET response instead, and its body is dropped.
+	if r.Method == http.MethodHead {
+		r.Method = http.MethodGet
+		w = discardBody{w}
+	}
+
+	// HTMX partials get swapped into an existing page that already has the
+	// script, so don't bother.
+	inject := rp.opts.Script != "" &&
+		r.Header.Get("HX-Request") == "" &&
+		rp.shouldInject(r.URL.Path)
+
+	// Whether the response is HTML is only known once the upstream replies,
+	// so every response goes through this. HTML responses are buffered in
+	// full until the upstream is done, since the script goes near the end.
+	maybeMutate := &maybeMutateHTML{ResponseWriter: w, logger: rp.logger}
+	if err := rp.proxyWithHold(maybeMutate, r); err != nil {
+		rp.serveUnavailable(w, r, err)
+		return
+	}
 
-		if maybeMutate.isHTML == 1 {
-			if err := htmlMut.ApplyHTML(); err != nil {
-				writeBigError(w, err)
-			}
+	err := maybeMutate.apply(func(h http.Header, body []byte) []byte {
+		if rw, ok := rp.rewriter(r.Context()); ok {
+			body = rw.rewriteHTML(body)
 		}
-	default:
-		rp.ReverseProxy.ServeHTTP(w, r)
+		if inject {
+			body = rp.injectScript(h, body)
+		}
+		return body
+	})
+	if err != nil {
+		rp.logger.Debug("cannot write HTML response", "path", r.URL.Path, "err", err)
 	}
 }
 
-type maybeMutateHTML struct {
-	*proxy.HTMLMutatorWriter
-	bypass http.ResponseWriter
-	isHTML int
+func (rp *ReverseProxy) modifyResponse(resp *http.Response) error {
+	if rw, ok := rp.rewriter(resp.Request.Context()); ok {
+		rw.rewriteHeaders(resp.Header)
+	}
+	return nil
 }
 
-var _ http.ResponseWriter = (*maybeMutateHTML)(nil)
+// rewriter returns the URL rewriter for the request with the given context.
+func (rp *ReverseProxy) rewriter(ctx context.Context) (urlRewriter, bool) {
+	target, ok := originFromContext(ctx)
+	if !ok {
+		return urlRewriter{}, false
+	}
 
-func (m *maybeMutateHTML) Write(b []byte) (int, error) {
-	if m.isHTML == 0 {
-		contentType := m.Header().Get("Content-Type")
-		if contentType == "" || strings.HasPrefix(contentType, "text/html") {
-			m.isHTML = 1
-		} else {
-			m.isHTML = -1
-		}
+	return urlRewriter{
+		upstream: origin{rp.targetURL.Scheme, rp.targetURL.Host},
+		target:   target,
+	}, true
+}
+
+// CloseUpgraded closes all proxied connections that were upgraded to another
+// protocol, such as WebSockets. This should be called when the upstream
+// restarts so that clients can reconnect to it.
+func (rp *ReverseProxy) CloseUpgraded() {
+	rp.upgrades.closeAll()
+}
+
+func (rp *ReverseProxy) shouldInject(urlPath string) bool {
+	if len(rp.opts.InjectInclude) > 0 && !matchAnyPath(rp.opts.InjectInclude, urlPath) {
+		return false
 	}
-	switch m.isHTML {
-	case 1:
-		return m.HTMLMutatorWriter.Write(b)
-	case -1:
-		return m.bypass.Write(b)
-	default:
-		panic("unreachable")
+	return !matchAnyPath(rp.opts.InjectExclude, urlPath)
+}
+
+func (rp *ReverseProxy) injectScript(h http.Header, body []byte) []byte {
+	policy := strings.Join(h.Values(cspHeader), ", ")
+
+	tag, rewritten := applyCSP(h, rp.opts.CSPMode, rp.opts.Script)
+	if rewritten != nil {
+		if _, warned := rp.cspWarned.LoadOrStore(policy, struct{}{}); !warned {
+			rp.logger.Warn("rewrote Content-Security-Policy to allow the injected script",
+				"policy", policy, "rewritten", strings.Join(rewritten, ", "))
+		}
 	}
+
+	return injectScript(body, tag)
 }
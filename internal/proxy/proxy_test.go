@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestReverseProxyHead(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"html", "text/html; charset=utf-8", "<html><body>hi</body></html>"},
+		{"not html", "text/plain", "hi"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var methods []string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				methods = append(methods, r.Method)
+				w.Header().Set("Content-Type", test.contentType)
+				io.WriteString(w, test.body)
+			}))
+			defer upstream.Close()
+
+			target, _ := url.Parse(upstream.URL)
+			server := httptest.NewServer(NewReverseProxy(*target, Opts{Script: "x()"}))
+			defer server.Close()
+
+			lengths := map[string]int64{}
+			for _, method := range []string{"GET", "HEAD"} {
+				req, _ := http.NewRequest(method, server.URL, nil)
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				if resp.StatusCode != 200 {
+					t.Errorf("%s: status = %d, want 200", method, resp.StatusCode)
+				}
+				if method == "HEAD" && len(body) > 0 {
+					t.Errorf("HEAD: got body %q", body)
+				}
+				lengths[method] = resp.ContentLength
+			}
+
+			if lengths["GET"] != lengths["HEAD"] {
+				t.Errorf("Content-Length of HEAD = %d, GET = %d", lengths["HEAD"], lengths["GET"])
+			}
+			if want := []string{"GET", "GET"}; !reflect.DeepEqual(methods, want) {
+				t.Errorf("upstream got %v, want %v", methods, want)
+			}
+		})
+	}
+}
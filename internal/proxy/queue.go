@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// QueueOpts are options for queueing requests while the upstream is down.
+type QueueOpts struct {
+	// MaxRequests is the maximum number of requests that can be waiting at
+	// once. Zero disables queueing.
+	MaxRequests int
+	// MaxBodySize is the maximum size of a request body that will be buffered
+	// for replaying. Requests with larger bodies are forwarded without waiting.
+	MaxBodySize int64
+	// Timeout is how long a request may wait for the upstream.
+	Timeout time.Duration
+}
+
+var errQueueFull = errors.New("too many requests are waiting for the upstream")
+
+// requestQueue holds requests until the upstream is alive.
+type requestQueue struct {
+	opts     QueueOpts
+	upstream Upstream
+	slots    chan struct{}
+	logger   *slog.Logger
+}
+
+func newRequestQueue(opts QueueOpts, upstream Upstream, logger *slog.Logger) *requestQueue {
+	if opts.MaxRequests <= 0 || upstream == nil {
+		return nil
+	}
+	return &requestQueue{
+		opts:     opts,
+		upstream: upstream,
+		slots:    make(chan struct{}, opts.MaxRequests),
+		logger:   logger,
+	}
+}
+
+// hold waits until the upstream is alive if it isn't, buffering the request
+// body first so that it can be replayed. The returned request should be used
+// instead of r.
+func (q *requestQueue) hold(r *http.Request) (*http.Request, error) {
+	if q == nil || q.upstream.Status().Alive {
+		return r, nil
+	}
+
+	r, ok := q.bufferBody(r)
+	if !ok {
+		return r, nil
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		defer func() { <-q.slots }()
+	default:
+		return r, errQueueFull
+	}
+
+	q.logger.Info("upstream is down, queueing request", "method", r.Method, "path", r.URL.Path)
+
+	ctx, cancel := context.WithTimeout(r.Context(), q.opts.Timeout)
+	defer cancel()
+
+	if err := q.upstream.WaitAlive(ctx); err != nil {
+		return r, fmt.Errorf("upstream did not come back in time: %w", err)
+	}
+
+	q.logger.Info("upstream is back, replaying request", "method", r.Method, "path", r.URL.Path)
+	return r, nil
+}
+
+// bufferBody reads the request body into memory and makes it replayable. If
+// the body is too large, then false is returned, and the request is returned
+// with what's been read put back in front of the body.
+func (q *requestQueue) bufferBody(r *http.Request) (*http.Request, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, q.opts.MaxBodySize+1))
+	if err != nil || int64(len(body)) > q.opts.MaxBodySize {
+		r = r.Clone(r.Context())
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return r, false
+	}
+	r.Body.Close()
+
+	r = r.Clone(r.Context())
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return r, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestQueueHold(t *testing.T) {
+	const body = "hello"
+
+	tests := []struct {
+		name     string
+		alive    bool
+		comeBack bool
+		maxBody  int64
+		body     string
+		wantErr  bool
+		wantWait bool
+		// replayable is true if the body should be buffered for replaying.
+		replayable bool
+	}{
+		{
+			name:  "alive",
+			alive: true,
+			body:  body,
+		},
+		{
+			name:       "comes back",
+			comeBack:   true,
+			body:       body,
+			wantWait:   true,
+			replayable: true,
+		},
+		{
+			name:     "comes back without body",
+			comeBack: true,
+			wantWait: true,
+		},
+		{
+			name:     "times out",
+			body:     body,
+			wantErr:  true,
+			wantWait: true,
+		},
+		{
+			name:    "body too large",
+			maxBody: 2,
+			body:    body,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstream := newFakeUpstream(test.alive)
+
+			maxBody := test.maxBody
+			if maxBody == 0 {
+				maxBody = 1 << 10
+			}
+
+			q := newRequestQueue(QueueOpts{
+				MaxRequests: 1,
+				MaxBodySize: maxBody,
+				Timeout:     50 * time.Millisecond,
+			}, upstream, slog.Default())
+
+			r := httptest.NewRequest("POST", "/api", strings.NewReader(test.body))
+			if test.body == "" {
+				r.Body = http.NoBody
+			}
+
+			if test.comeBack {
+				time.AfterFunc(10*time.Millisecond, upstream.setAlive)
+			}
+
+			r, err := q.hold(r)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("err = %v, want error %v", err, test.wantErr)
+			}
+			if test.wantWait != (upstream.waitCount() > 0) {
+				t.Errorf("waited = %v, want %v", upstream.waitCount() > 0, test.wantWait)
+			}
+			if test.wantErr {
+				return
+			}
+
+			if test.replayable != (r.GetBody != nil) {
+				t.Fatalf("replayable = %v, want %v", r.GetBody != nil, test.replayable)
+			}
+
+			// The body must still be there in full, buffered or not, and a
+			// buffered one must be readable again for every attempt.
+			attempts := 1
+			if test.replayable {
+				attempts = 2
+			}
+			for i := 0; i < attempts; i++ {
+				b := r.Body
+				if i > 0 {
+					b, _ = r.GetBody()
+				}
+				got, _ := io.ReadAll(b)
+				if string(got) != test.body {
+					t.Errorf("attempt %d: body = %q, want %q", i, got, test.body)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestQueueFull(t *testing.T) {
+	upstream := newFakeUpstream(false)
+	defer upstream.setAlive()
+
+	q := newRequestQueue(QueueOpts{
+		MaxRequests: 1,
+		MaxBodySize: 1 << 10,
+		Timeout:     time.Second,
+	}, upstream, slog.Default())
+
+	held := make(chan error, 1)
+	go func() {
+		_, err := q.hold(httptest.NewRequest("GET", "/first", nil))
+		held <- err
+	}()
+
+	// Wait for the first request to take the only slot.
+	for upstream.waitCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := q.hold(httptest.NewRequest("GET", "/second", nil)); !errors.Is(err, errQueueFull) {
+		t.Errorf("second request: err = %v, want errQueueFull", err)
+	}
+
+	upstream.setAlive()
+	if err := <-held; err != nil {
+		t.Errorf("first request: unexpected error: %v", err)
+	}
+}
+
+func TestRequestQueueDisabled(t *testing.T) {
+	if q := newRequestQueue(QueueOpts{}, newFakeUpstream(false), slog.Default()); q != nil {
+		t.Fatal("queue is enabled without MaxRequests")
+	}
+
+	var q *requestQueue
+	r := httptest.NewRequest("GET", "/", nil)
+	if held, err := q.hold(r); err != nil || held != r {
+		t.Errorf("nil queue changed the request: %v, %v", held, err)
+	}
+}
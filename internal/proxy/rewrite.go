@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	htmltok "golang.org/x/net/html"
+)
+
+// origin is a scheme and host pair.
+type origin struct {
+	scheme string
+	host   string
+}
+
+func (o origin) String() string {
+	return o.scheme + "://" + o.host
+}
+
+// requestOrigin returns the origin that the client used to reach us.
+func requestOrigin(r *http.Request) origin {
+	if r.TLS != nil {
+		return origin{"https", r.Host}
+	}
+	return origin{"http", r.Host}
+}
+
+type originKey struct{}
+
+func withOrigin(ctx context.Context, o origin) context.Context {
+	return context.WithValue(ctx, originKey{}, o)
+}
+
+func originFromContext(ctx context.Context) (origin, bool) {
+	o, ok := ctx.Value(originKey{}).(origin)
+	return o, ok
+}
+
+// urlRewriter rewrites URLs pointing at the upstream to point at us instead.
+type urlRewriter struct {
+	upstream origin
+	target   origin
+}
+
+// rewriteURL rewrites the URL if it points to the upstream. Relative URLs are
+// left alone.
+func (rw urlRewriter) rewriteURL(s string) string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Host == "" {
+		return s
+	}
+
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = rw.upstream.scheme
+	}
+
+	if !strings.EqualFold(scheme, rw.upstream.scheme) ||
+		!sameHost(scheme, u.Host, rw.upstream.scheme, rw.upstream.host) {
+		return s
+	}
+
+	// Keep protocol-relative URLs protocol-relative.
+	if u.Scheme != "" {
+		u.Scheme = rw.target.scheme
+	}
+	u.Host = rw.target.host
+	return u.String()
+}
+
+// sameHost compares two hosts, taking default ports into account.
+func sameHost(scheme1, host1, scheme2, host2 string) bool {
+	return strings.EqualFold(hostWithPort(scheme1, host1), hostWithPort(scheme2, host2))
+}
+
+func hostWithPort(scheme, host string) string {
+	if u := (url.URL{Host: host}); u.Port() != "" {
+		return host
+	}
+	switch strings.ToLower(scheme) {
+	case "https", "wss":
+		return host + ":443"
+	default:
+		return host + ":80"
+	}
+}
+
+// rewriteRefresh rewrites the URL in a Refresh header or <meta http-equiv>
+// value, which looks like "5; url=http://example.com".
+func (rw urlRewriter) rewriteRefresh(s string) string {
+	delay, rest, ok := strings.Cut(s, ";")
+	if !ok {
+		return s
+	}
+
+	rest = strings.TrimSpace(rest)
+	if len(rest) < 4 || !strings.EqualFold(rest[:4], "url=") {
+		return s
+	}
+
+	return delay + "; url=" + rw.rewriteURL(strings.Trim(rest[4:], `'"`))
+}
+
+// rewriteSetCookie drops the Domain attribute if it is the upstream's, since
+// the browser would otherwise refuse the cookie. If the target isn't HTTPS,
+// the Secure attribute is dropped as well.
+func (rw urlRewriter) rewriteSetCookie(s string) string {
+	attrs := strings.Split(s, ";")
+
+	upstreamHost := (&url.URL{Host: rw.upstream.host}).Hostname()
+	insecure := rw.target.scheme != "https"
+
+	kept := attrs[:1]
+	for _, attr := range attrs[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		switch {
+		case strings.EqualFold(name, "Domain"):
+			if strings.EqualFold(strings.TrimPrefix(value, "."), upstreamHost) {
+				continue
+			}
+		case strings.EqualFold(name, "Secure"):
+			if insecure {
+				continue
+			}
+		case strings.EqualFold(name, "SameSite"):
+			// SameSite=None requires Secure.
+			if insecure && strings.EqualFold(value, "None") {
+				attr = " SameSite=Lax"
+			}
+		}
+		kept = append(kept, attr)
+	}
+
+	return strings.Join(kept, ";")
+}
+
+// rewriteHeaders rewrites the response headers that may contain URLs.
+func (rw urlRewriter) rewriteHeaders(h http.Header) {
+	for _, key := range []string{"Location", "Content-Location"} {
+		if v := h.Get(key); v != "" {
+			h.Set(key, rw.rewriteURL(v))
+		}
+	}
+
+	if v := h.Get("Refresh"); v != "" {
+		h.Set("Refresh", rw.rewriteRefresh(v))
+	}
+
+	cookies := h.Values("Set-Cookie")
+	for i, cookie := range cookies {
+		cookies[i] = rw.rewriteSetCookie(cookie)
+	}
+}
+
+// urlAttrs are the HTML attributes that contain URLs.
+var urlAttrs = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+}
+
+// rewriteHTML rewrites the URL attributes in the HTML document. Only the tags
+// that have changed are re-rendered, so the rest of the document is left
+// byte-for-byte the same.
+func (rw urlRewriter) rewriteHTML(body []byte) []byte {
+	if !bytes.Contains(body, []byte(rw.upstream.host)) {
+		return body
+	}
+
+	out := make([]byte, 0, len(body))
+
+	z := htmltok.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == htmltok.ErrorToken {
+			if z.Err() != io.EOF {
+				return body
+			}
+			break
+		}
+
+		raw := z.Raw()
+		if (tt != htmltok.StartTagToken && tt != htmltok.SelfClosingTagToken) ||
+			!bytes.Contains(raw, []byte(rw.upstream.host)) {
+			out = append(out, raw...)
+			continue
+		}
+
+		// Copy the raw bytes first, since Token might change them.
+		raw = append([]byte(nil), raw...)
+
+		token := z.Token()
+		changed := false
+
+		isRefresh := token.Data == "meta" && hasAttr(token.Attr, "http-equiv", "refresh")
+
+		for i, attr := range token.Attr {
+			var value string
+			switch {
+			case urlAttrs[attr.Key]:
+				value = rw.rewriteURL(attr.Val)
+			case isRefresh && attr.Key == "content":
+				value = rw.rewriteRefresh(attr.Val)
+			default:
+				continue
+			}
+			if value != attr.Val {
+				token.Attr[i].Val = value
+				changed = true
+			}
+		}
+
+		if changed {
+			out = append(out, token.String()...)
+		} else {
+			out = append(out, raw...)
+		}
+	}
+
+	return out
+}
+
+func hasAttr(attrs []htmltok.Attribute, key, value string) bool {
+	for _, attr := range attrs {
+		if attr.Key == key && strings.EqualFold(attr.Val, value) {
+			return true
+		}
+	}
+	return false
+}
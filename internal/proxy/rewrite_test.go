@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+var testRewriter = urlRewriter{
+	upstream: origin{"http", "localhost:8081"},
+	target:   origin{"https", "localhost:8080"},
+}
+
+func TestRewriteURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"http://localhost:8081/login?next=/", "https://localhost:8080/login?next=/"},
+		{"HTTP://LOCALHOST:8081/", "https://localhost:8080/"},
+		{" http://localhost:8081/a ", "https://localhost:8080/a"},
+		{"//localhost:8081/a", "//localhost:8080/a"},
+		{"/login", "/login"},
+		{"login", "login"},
+		{"http://localhost:8082/", "http://localhost:8082/"},
+		{"http://example.com/", "http://example.com/"},
+		{"https://localhost:8081/", "https://localhost:8081/"},
+		{"mailto:a@localhost", "mailto:a@localhost"},
+		{"http://[::1", "http://[::1"},
+	}
+
+	for _, test := range tests {
+		if got := testRewriter.rewriteURL(test.in); got != test.want {
+			t.Errorf("rewriteURL(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRewriteURLDefaultPort(t *testing.T) {
+	rw := urlRewriter{
+		upstream: origin{"http", "example.com"},
+		target:   origin{"http", "localhost:8080"},
+	}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"http://example.com/a", "http://localhost:8080/a"},
+		{"http://example.com:80/a", "http://localhost:8080/a"},
+		{"http://example.com:8000/a", "http://example.com:8000/a"},
+		{"http://www.example.com/a", "http://www.example.com/a"},
+	}
+
+	for _, test := range tests {
+		if got := rw.rewriteURL(test.in); got != test.want {
+			t.Errorf("rewriteURL(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRewriteSetCookie(t *testing.T) {
+	insecure := urlRewriter{
+		upstream: origin{"https", "api.example.com"},
+		target:   origin{"http", "localhost:8080"},
+	}
+	secure := urlRewriter{
+		upstream: origin{"https", "api.example.com"},
+		target:   origin{"https", "localhost:8080"},
+	}
+
+	tests := []struct {
+		name string
+		rw   urlRewriter
+		in   string
+		want string
+	}{
+		{
+			name: "upstream domain",
+			rw:   secure,
+			in:   "id=1; Domain=api.example.com; Path=/",
+			want: "id=1; Path=/",
+		},
+		{
+			name: "upstream domain with dot",
+			rw:   secure,
+			in:   "id=1; Path=/; domain=.API.example.com",
+			want: "id=1; Path=/",
+		},
+		{
+			name: "other domain",
+			rw:   secure,
+			in:   "id=1; Domain=example.com",
+			want: "id=1; Domain=example.com",
+		},
+		{
+			name: "secure kept",
+			rw:   secure,
+			in:   "id=1; Secure; SameSite=None",
+			want: "id=1; Secure; SameSite=None",
+		},
+		{
+			name: "secure dropped",
+			rw:   insecure,
+			in:   "id=1; Secure; HttpOnly",
+			want: "id=1; HttpOnly",
+		},
+		{
+			name: "samesite none",
+			rw:   insecure,
+			in:   "id=1; Secure; SameSite=None",
+			want: "id=1; SameSite=Lax",
+		},
+		{
+			name: "samesite strict",
+			rw:   insecure,
+			in:   "id=1; SameSite=Strict",
+			want: "id=1; SameSite=Strict",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.rw.rewriteSetCookie(test.in); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRewriteHeaders(t *testing.T) {
+	h := http.Header{
+		"Location":         {"http://localhost:8081/home"},
+		"Content-Location": {"http://localhost:8081/home.html"},
+		"Refresh":          {"5; URL='http://localhost:8081/next'"},
+		"Set-Cookie":       {"a=1; Domain=localhost", "b=2; Domain=example.com"},
+		"Link":             {"<http://localhost:8081/style.css>; rel=preload"},
+	}
+	want := http.Header{
+		"Location":         {"https://localhost:8080/home"},
+		"Content-Location": {"https://localhost:8080/home.html"},
+		"Refresh":          {"5; url=https://localhost:8080/next"},
+		"Set-Cookie":       {"a=1", "b=2; Domain=example.com"},
+		"Link":             {"<http://localhost:8081/style.css>; rel=preload"},
+	}
+
+	testRewriter.rewriteHeaders(h)
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("header = %v, want %v", h, want)
+	}
+}
+
+func TestRewriteRefresh(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"0; url=http://localhost:8081/", "0; url=https://localhost:8080/"},
+		{`3;URL="http://localhost:8081/a"`, "3; url=https://localhost:8080/a"},
+		{"0; url=http://example.com/", "0; url=http://example.com/"},
+		{"5", "5"},
+		{"5; http://localhost:8081/", "5; http://localhost:8081/"},
+	}
+
+	for _, test := range tests {
+		if got := testRewriter.rewriteRefresh(test.in); got != test.want {
+			t.Errorf("rewriteRefresh(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRewriteHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "attributes",
+			in:   `<a href="http://localhost:8081/a">a</a><img src='http://localhost:8081/b.png'>`,
+			want: `<a href="https://localhost:8080/a">a</a><img src="https://localhost:8080/b.png">`,
+		},
+		{
+			name: "forms",
+			in:   `<form action="http://localhost:8081/login"><button formaction="//localhost:8081/x">`,
+			want: `<form action="https://localhost:8080/login"><button formaction="//localhost:8080/x">`,
+		},
+		{
+			name: "meta refresh",
+			in:   `<meta http-equiv="Refresh" content="0; url=http://localhost:8081/">`,
+			want: `<meta http-equiv="Refresh" content="0; url=https://localhost:8080/">`,
+		},
+		{
+			name: "other upstream",
+			in:   `<a   href="http://localhost:8082/a" >a</a>`,
+			want: `<a   href="http://localhost:8082/a" >a</a>`,
+		},
+		{
+			name: "other attributes",
+			in:   `<a   data-url="http://localhost:8081/a" >a</a>`,
+			want: `<a   data-url="http://localhost:8081/a" >a</a>`,
+		},
+		{
+			name: "text",
+			in:   `<p>see http://localhost:8081/a</p><!-- <a href="http://localhost:8081/"> -->`,
+			want: `<p>see http://localhost:8081/a</p><!-- <a href="http://localhost:8081/"> -->`,
+		},
+		{
+			name: "untouched tags",
+			in:   `<DIV   class=x><a href="http://localhost:8081/">`,
+			want: `<DIV   class=x><a href="https://localhost:8080/">`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(testRewriter.rewriteHTML([]byte(test.in))); got != test.want {
+				t.Errorf("got  %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Upstream reports on the upstream server so that the proxy can do something
+// smarter than failing when it's down.
+type Upstream interface {
+	// Status returns the current status of the upstream.
+	Status() UpstreamStatus
+	// WaitAlive blocks until the upstream is alive or ctx is done. It returns
+	// right away if the upstream is already alive.
+	WaitAlive(ctx context.Context) error
+}
+
+// UpstreamStatus is the status of an upstream.
+type UpstreamStatus struct {
+	// Alive is true if the upstream is ready to take requests.
+	Alive bool
+	// State describes the state of the upstream, such as "alive" or "dead".
+	State string
+	// Restarting is true if the upstream is being restarted.
+	Restarting bool
+}
+
+// proxyAttempt records the error of a single attempt at proxying a request.
+// The ErrorHandler stores the error here instead of writing it out, so that
+// ServeHTTP can decide what to do with it.
+type proxyAttempt struct {
+	err error
+}
+
+type proxyAttemptKey struct{}
+
+func (rp *ReverseProxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if attempt, ok := r.Context().Value(proxyAttemptKey{}).(*proxyAttempt); ok {
+		attempt.err = err
+		return
+	}
+
+	rp.logger.Warn("cannot proxy request", "path", r.URL.Path, "err", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// proxyWithHold proxies the request, retrying it if the upstream comes back up
+// within the hold timeout. If it never does, then the last error is returned
+// and nothing is written.
+func (rp *ReverseProxy) proxyWithHold(w http.ResponseWriter, r *http.Request) error {
+	r, err := rp.queue.hold(r)
+	if err != nil {
+		return err
+	}
+
+	var deadline time.Time
+	if timeout := rp.holdTimeout(); timeout > 0 && rp.opts.Upstream != nil {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		attempt := &proxyAttempt{}
+		attemptReq := r.WithContext(context.WithValue(r.Context(), proxyAttemptKey{}, attempt))
+		if r.GetBody != nil {
+			attemptReq.Body, _ = r.GetBody()
+		}
+
+		rp.ReverseProxy.ServeHTTP(w, attemptReq)
+		if attempt.err == nil {
+			return nil
+		}
+
+		rp.logger.Debug("cannot proxy request", "path", r.URL.Path, "err", attempt.err)
+
+		if !rp.holdUntil(r, deadline) {
+			return attempt.err
+		}
+
+		rp.logger.Info("upstream is back, retrying request", "path", r.URL.Path)
+	}
+}
+
+// holdTimeout returns how long a failed request may be held for.
+func (rp *ReverseProxy) holdTimeout() time.Duration {
+	if rp.queue != nil && rp.opts.Queue.Timeout > rp.opts.HoldTimeout {
+		return rp.opts.Queue.Timeout
+	}
+	return rp.opts.HoldTimeout
+}
+
+// holdUntil waits for the upstream to be alive again until the deadline. It
+// returns false if the request shouldn't be retried.
+func (rp *ReverseProxy) holdUntil(r *http.Request, deadline time.Time) bool {
+	// The body may have been consumed by the failed attempt, so requests with
+	// one can only be replayed if it was buffered.
+	if deadline.IsZero() || (r.Body != nil && r.Body != http.NoBody && r.GetBody == nil) {
+		return false
+	}
+
+	// If the upstream is up, then the request failed for some other reason,
+	// and retrying it won't help.
+	if rp.opts.Upstream.Status().Alive {
+		return false
+	}
+
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	defer cancel()
+
+	return rp.opts.Upstream.WaitAlive(ctx) == nil
+}
+
+var unavailablePage = template.Must(template.New("unavailable").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{ .Title }}</title>
+	<style>
+		body {
+			font-family: system-ui, sans-serif;
+			max-width: 40em;
+			margin: 4em auto;
+			padding: 0 1em;
+			color: #333;
+		}
+		@media (prefers-color-scheme: dark) {
+			body { color: #ddd; background: #222; }
+		}
+		pre { white-space: pre-wrap; opacity: 0.75; }
+		dt { font-weight: bold; }
+	</style>
+</head>
+<body>
+	<h1>{{ .Title }}</h1>
+	<p>This page will reload once the upstream is alive.</p>
+	<dl>
+		<dt>Upstream</dt>
+		<dd><code>{{ .Upstream }}</code></dd>
+		{{ with .Status }}
+		<dt>State</dt>
+		<dd>{{ .State }}{{ if .Restarting }}, restarting{{ end }}</dd>
+		{{ end }}
+	</dl>
+	<pre>{{ .Error }}</pre>
+	<script>
+		// In case the live reload signal is missed.
+		setInterval(() => {
+			fetch(location.href, { method: "HEAD", cache: "no-store" })
+				.then((r) => r.status != 503 && location.reload());
+		}, 2000);
+	</script>
+</body>
+</html>
+`))
+
+// serveUnavailable serves a page telling the user that the upstream is
+// unavailable. The page has the script injected, so it reloads itself once
+// the upstream is back.
+func (rp *ReverseProxy) serveUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	var status *UpstreamStatus
+	if rp.opts.Upstream != nil {
+		s := rp.opts.Upstream.Status()
+		status = &s
+	}
+
+	title := "Upstream is unavailable"
+	if status != nil && status.Restarting {
+		title = "Upstream is restarting"
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", "1")
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%s: %v\n", title, err)
+		return
+	}
+
+	var page strings.Builder
+	unavailablePage.Execute(&page, struct {
+		Title    string
+		Upstream string
+		Status   *UpstreamStatus
+		Error    error
+	}{
+		Title:    title,
+		Upstream: rp.targetURL.String(),
+		Status:   status,
+		Error:    err,
+	})
+
+	body := []byte(page.String())
+	if rp.opts.Script != "" {
+		body = rp.injectScript(w.Header(), body)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(body)
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUpstream is an Upstream that is down until setAlive is called.
+type fakeUpstream struct {
+	once  sync.Once
+	alive chan struct{}
+	waits int
+	mu    sync.Mutex
+}
+
+func newFakeUpstream(alive bool) *fakeUpstream {
+	u := &fakeUpstream{alive: make(chan struct{})}
+	if alive {
+		u.setAlive()
+	}
+	return u
+}
+
+func (u *fakeUpstream) setAlive() {
+	u.once.Do(func() { close(u.alive) })
+}
+
+func (u *fakeUpstream) Status() UpstreamStatus {
+	select {
+	case <-u.alive:
+		return UpstreamStatus{Alive: true, State: "alive"}
+	default:
+		return UpstreamStatus{State: "dead"}
+	}
+}
+
+func (u *fakeUpstream) WaitAlive(ctx context.Context) error {
+	u.mu.Lock()
+	u.waits++
+	u.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-u.alive:
+		return nil
+	}
+}
+
+func (u *fakeUpstream) waitCount() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.waits
+}
+
+func TestHoldUntil(t *testing.T) {
+	tests := []struct {
+		name     string
+		alive    bool
+		comeBack bool
+		deadline time.Duration
+		body     string
+		buffered bool
+		want     bool
+		wantWait bool
+	}{
+		{
+			name:     "comes back",
+			comeBack: true,
+			deadline: time.Second,
+			want:     true,
+			wantWait: true,
+		},
+		{
+			name:     "times out",
+			deadline: 20 * time.Millisecond,
+			wantWait: true,
+		},
+		{
+			name:     "no hold",
+			comeBack: true,
+		},
+		{
+			name:     "already alive",
+			alive:    true,
+			deadline: time.Second,
+		},
+		{
+			name:     "unbuffered body",
+			comeBack: true,
+			deadline: time.Second,
+			body:     "hello",
+		},
+		{
+			name:     "buffered body",
+			comeBack: true,
+			deadline: time.Second,
+			body:     "hello",
+			buffered: true,
+			want:     true,
+			wantWait: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstream := newFakeUpstream(test.alive)
+			rp := &ReverseProxy{opts: Opts{Upstream: upstream}}
+
+			r := httptest.NewRequest("POST", "/api", strings.NewReader(test.body))
+			if test.body == "" {
+				r.Body = http.NoBody
+			}
+			if test.buffered {
+				r, _ = (&requestQueue{opts: QueueOpts{MaxBodySize: 1 << 10}}).bufferBody(r)
+			}
+
+			if test.comeBack {
+				time.AfterFunc(10*time.Millisecond, upstream.setAlive)
+			}
+
+			var deadline time.Time
+			if test.deadline > 0 {
+				deadline = time.Now().Add(test.deadline)
+			}
+
+			if got := rp.holdUntil(r, deadline); got != test.want {
+				t.Errorf("holdUntil = %v, want %v", got, test.want)
+			}
+			if waited := upstream.waitCount() > 0; waited != test.wantWait {
+				t.Errorf("waited = %v, want %v", waited, test.wantWait)
+			}
+		})
+	}
+}
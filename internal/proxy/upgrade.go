@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// isUpgrade returns true if the request asks to switch protocols, such as to
+// a WebSocket.
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" &&
+		httpguts.HeaderValuesContainsToken(r.Header["Connection"], "Upgrade")
+}
+
+// upgradeTracker keeps track of connections that were hijacked for protocol
+// upgrades so they can be closed when the upstream restarts.
+type upgradeTracker struct {
+	mu     sync.Mutex
+	conns  map[*upgradedConn]struct{}
+	logger *slog.Logger
+}
+
+func (t *upgradeTracker) add(c *upgradedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conns == nil {
+		t.conns = make(map[*upgradedConn]struct{})
+	}
+	t.conns[c] = struct{}{}
+}
+
+func (t *upgradeTracker) remove(c *upgradedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, c)
+}
+
+// closeAll closes all tracked connections.
+func (t *upgradeTracker) closeAll() {
+	t.mu.Lock()
+	conns := make([]*upgradedConn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+
+	for _, c := range conns {
+		if err := c.closeRestart(); err != nil {
+			t.logger.Debug("cannot close upgraded connection", "err", err)
+		}
+	}
+}
+
+// upgradeWriter is a ResponseWriter that tracks the connection once it's
+// hijacked.
+type upgradeWriter struct {
+	http.ResponseWriter
+	tracker   *upgradeTracker
+	websocket bool
+}
+
+var _ http.Hijacker = (*upgradeWriter)(nil)
+
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	upgraded := &upgradedConn{
+		Conn:      conn,
+		tracker:   w.tracker,
+		websocket: w.websocket,
+	}
+	w.tracker.add(upgraded)
+
+	return upgraded, brw, nil
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (w *upgradeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// upgradedConn is a hijacked connection.
+type upgradedConn struct {
+	net.Conn
+	tracker   *upgradeTracker
+	websocket bool
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func (c *upgradedConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *upgradedConn) Close() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.close()
+}
+
+func (c *upgradedConn) close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.tracker.remove(c)
+	return c.Conn.Close()
+}
+
+// wsCloseServiceRestart is the WebSocket close code telling the client that
+// the server is restarting and that it should reconnect.
+const wsCloseServiceRestart = 1012
+
+// closeRestart closes the connection. For WebSockets, a close frame is sent
+// first so that clients know to reconnect. By the time this is called, the
+// upstream is usually already gone, so nothing else should be writing.
+func (c *upgradedConn) closeRestart() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	if c.websocket {
+		if _, err := c.Conn.Write(wsCloseFrame(wsCloseServiceRestart, "service restart")); err != nil {
+			c.tracker.logger.Debug("cannot send WebSocket close frame", "err", err)
+		}
+	}
+
+	return c.close()
+}
+
+// wsCloseFrame creates an unmasked WebSocket close frame. The reason must be
+// shorter than 124 bytes.
+func wsCloseFrame(code uint16, reason string) []byte {
+	if len(reason) > 123 {
+		panic(fmt.Sprintf("close reason %q too long", reason))
+	}
+
+	frame := make([]byte, 4, 4+len(reason))
+	frame[0] = 0x80 | 0x8 // FIN, close opcode
+	frame[1] = byte(2 + len(reason))
+	binary.BigEndian.PutUint16(frame[2:], code)
+	return append(frame, reason...)
+}
+
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
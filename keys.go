@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// keyHelp is printed when ? is pressed.
+const keyHelp = "keys: r restart, b reload browsers, o open browser, c clear, v toggle debug logging, p pause/resume, q quit, ? help"
+
+// Special keys that aren't a single byte.
+const (
+	keyUp = 0x100 + iota
+	keyDown
+	keyPageUp
+	keyPageDown
+)
+
+// escapeKeys maps escape sequences to special keys.
+var escapeKeys = map[string]int{
+	"\x1b[A":  keyUp,
+	"\x1b[B":  keyDown,
+	"\x1b[5~": keyPageUp,
+	"\x1b[6~": keyPageDown,
+}
+
+// keyActions are what the keys do.
+type keyActions struct {
+	restart  func()
+	reload   func()
+	open     func()
+	stop     func()
+	observer *Observer
+
+	// level is the log level that v toggles between debug and quietLevel,
+	// which should be the level that saq started with.
+	level      *slog.LevelVar
+	quietLevel slog.Level
+
+	// out is where messages go. Defaults to stderr.
+	out io.Writer
+	// clear clears the screen. Defaults to clearing the terminal.
+	clear func()
+	// scroll scrolls by the given number of lines, or pages if page is true.
+	// Scrolling is ignored if nil.
+	scroll func(lines int, page bool)
+}
+
+// handle handles a single key press.
+func (k *keyActions) handle(key int) {
+	switch key {
+	case 'r':
+		k.println("restarting")
+		k.restart()
+	case 'b':
+		k.println("reloading browsers")
+		k.reload()
+	case 'o':
+		k.open()
+	case 'c':
+		if k.clear != nil {
+			k.clear()
+		} else {
+			fmt.Fprint(os.Stderr, "\x1b[H\x1b[2J")
+		}
+	case 'v':
+		if k.level == nil {
+			break
+		}
+		if k.level.Level() != slog.LevelDebug {
+			k.level.Set(slog.LevelDebug)
+			k.println("debug logging on")
+		} else {
+			k.level.Set(k.quietLevel)
+			k.println("debug logging off")
+		}
+	case 'p':
+		if k.observer.Paused() {
+			k.observer.Resume()
+			k.println("resumed watching")
+		} else {
+			k.observer.Pause()
+			k.println("paused watching, press p again to resume")
+		}
+	case 'q':
+		k.println("quitting")
+		k.stop()
+	case '?', 'h':
+		k.println(keyHelp)
+	case keyUp, keyDown, keyPageUp, keyPageDown:
+		if k.scroll != nil {
+			switch key {
+			case keyUp:
+				k.scroll(1, false)
+			case keyDown:
+				k.scroll(-1, false)
+			case keyPageUp:
+				k.scroll(1, true)
+			case keyPageDown:
+				k.scroll(-1, true)
+			}
+		}
+	}
+}
+
+func (k *keyActions) output() io.Writer {
+	if k.out != nil {
+		return k.out
+	}
+	return os.Stderr
+}
+
+func (k *keyActions) println(msg string) {
+	fmt.Fprintln(k.output(), "saq:", msg)
+}
+
+// listenKeys reads single key presses from the terminal until ctx is done. The
+// terminal is put into cbreak mode, which is raw mode except that output and
+// Ctrl-C still work as usual. The returned function restores the terminal. If
+// stdin isn't a terminal, then nothing is done.
+func listenKeys(ctx context.Context, actions *keyActions) (restore func(), err error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return func() {}, nil
+	}
+
+	state, err := term.GetState(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	termios.Lflag &^= unix.ICANON | unix.ECHO
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+
+	actions.println("press ? for keys")
+
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil || ctx.Err() != nil {
+				return
+			}
+			for _, key := range parseKeys(buf[:n]) {
+				actions.handle(key)
+			}
+		}
+	}()
+
+	return func() { term.Restore(fd, state) }, nil
+}
+
+// parseKeys splits the bytes read from the terminal into keys. Escape sequences
+// are assumed to arrive in a single read.
+func parseKeys(b []byte) []int {
+	var keys []int
+	for len(b) > 0 {
+		if b[0] == 0x1b {
+			matched := false
+			for seq, key := range escapeKeys {
+				if strings.HasPrefix(string(b), seq) {
+					keys = append(keys, key)
+					b = b[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				// Skip unknown escape sequences entirely.
+				return keys
+			}
+			continue
+		}
+		keys = append(keys, int(b[0]))
+		b = b[1:]
+	}
+	return keys
+}
@@ -2,31 +2,77 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/browser"
 	"github.com/spf13/pflag"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/term"
 	"libdb.so/hserve"
 	"libdb.so/saq/internal/atomicg"
+	"libdb.so/saq/internal/fileserver"
+	"libdb.so/saq/internal/header"
+	"libdb.so/saq/internal/inspect"
+	"libdb.so/saq/internal/mock"
+	"libdb.so/saq/internal/netsim"
 	"libdb.so/saq/internal/proxy"
 )
 
 // hook is the script that is injected into the page to wait for the refresh
-// signal.
-const hook = `
-	<script>
-		fetch("/__refresh").then(() => window.location.reload());
-	</script>
-`
+// signal. If only stylesheets changed, they are swapped in place instead.
+const hook = `(function wait() {
+	fetch("/__refresh").then((r) => r.text()).then((kind) => {
+		if (kind != "css") return window.location.reload();
+		for (const link of document.querySelectorAll('link[rel="stylesheet"]')) {
+			const url = new URL(link.href);
+			url.searchParams.set("__saq", Date.now());
+			link.href = url;
+		}
+		wait();
+	});
+})();`
+
+// Reload is how the page should be reloaded.
+type Reload int
+
+const (
+	// ReloadPage reloads the whole page.
+	ReloadPage Reload = iota
+	// ReloadCSS swaps the stylesheets without reloading the page.
+	ReloadCSS
+)
+
+func (r Reload) String() string {
+	switch r {
+	case ReloadPage:
+		return "page"
+	case ReloadCSS:
+		return "css"
+	default:
+		return "invalid"
+	}
+}
+
+// reloadFor returns how the page should be reloaded when the files change.
+func reloadFor(files []string) Reload {
+	for _, file := range files {
+		if !strings.EqualFold(filepath.Ext(file), ".css") {
+			return ReloadPage
+		}
+	}
+	return ReloadCSS
+}
 
 var (
 	sourceURL        = "http://localhost:8081"
@@ -39,14 +85,53 @@ var (
 	noBrowser        = false
 	browserOpenOnce  = true
 	verbose          = false
+	cspMode          = "auto"
+	injectInclude    = []string{}
+	injectExclude    = []string{}
+	targetTLS        = false
+	targetTLSDir     = ""
+	sourceCA         = ""
+	sourceInsecure   = false
+	sourceCert       = ""
+	sourceKey        = ""
+	routeFlags       = []string{}
+	holdTimeout      = time.Duration(0)
+	queueMax         = 0
+	queueBodyMax     = int64(1 << 20)
+	queueTimeout     = 30 * time.Second
+	inspectSize      = 0
+	inspectBodyMax   = int64(64 << 10)
+	netsimFlags      = []string{}
+	mockPath         = ""
+	headerFlags      = []string{}
+	cors             = false
+	fileIndex        = []string{"index.html"}
+	fileSPA          = false
+	fileCleanURLs    = false
+	fileNotFound     = ""
+	fileNoListing    = false
+	fileMarkdown     = false
+	serveFlags       = []string{}
+	controlSocket    = ""
+	noKeys           = false
+	tui              = false
+	logLevelName     = "warn"
+	logFormat        = "text"
+	eventsFormat     = ""
+	eventsOutput     = "-"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtl(os.Args[2:]))
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
 	pflag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [flags...] argv...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s ctl [flags...] command\n", os.Args[0])
 		fmt.Fprintln(os.Stderr, "Flags:")
 		pflag.PrintDefaults()
 	}
@@ -54,13 +139,47 @@ func main() {
 	pflag.StringVarP(&includeDir, "include", "i", includeDir, "include directory")
 	pflag.StringSliceVarP(&excludeDirs, "exclude", "x", excludeDirs, "exclude directories/paths/globs (prefix ./ is required for path)")
 	pflag.StringVarP(&sourceURL, "source", "s", sourceURL, "source URL of the upstream server")
+	pflag.StringVar(&sourceCA, "source-ca", sourceCA, "PEM file of extra CAs to trust when the source is HTTPS")
+	pflag.BoolVar(&sourceInsecure, "source-insecure", sourceInsecure, "do not verify the source's TLS certificate")
+	pflag.StringVar(&sourceCert, "source-cert", sourceCert, "PEM file of the client certificate to present to the source")
+	pflag.StringVar(&sourceKey, "source-key", sourceKey, "PEM file of the client certificate's private key")
+	pflag.StringArrayVar(&routeFlags, "route", routeFlags, "route a path prefix to another source, e.g. /api=http://localhost:8081 (repeatable)")
+	pflag.DurationVar(&holdTimeout, "hold", holdTimeout, "how long to hold requests while the source is down before showing an error page")
+	pflag.IntVar(&queueMax, "queue", queueMax, "queue up to this many requests while the source is down and replay them once it's up, 0 to disable")
+	pflag.Int64Var(&queueBodyMax, "queue-body-max", queueBodyMax, "maximum request body size in bytes that can be queued")
+	pflag.DurationVar(&queueTimeout, "queue-timeout", queueTimeout, "how long a queued request waits for the source")
+	pflag.IntVar(&inspectSize, "inspect", inspectSize, "number of recent requests to keep for the inspector at /__saq/requests, 0 to disable")
+	pflag.Int64Var(&inspectBodyMax, "inspect-body-max", inspectBodyMax, "maximum bytes of each request and response body to keep for the inspector")
+	pflag.StringArrayVar(&netsimFlags, "netsim", netsimFlags, "simulate network conditions, e.g. /api/**:latency=200ms,bandwidth=50k,fail=0.1,status=503 (repeatable)")
+	pflag.StringVar(&mockPath, "mocks", mockPath, "directory or JSON config of mock responses to serve instead of proxying, empty to disable")
+	pflag.StringArrayVarP(&headerFlags, "header", "H", headerFlags, "set a response header, e.g. 'Cache-Control: no-store' or '/api/** X-Foo: bar', empty value to remove (repeatable)")
+	pflag.BoolVar(&cors, "cors", cors, "allow cross-origin requests from any origin and answer CORS preflight requests")
 	pflag.StringVarP(&targetAddr, "target", "t", targetAddr, "target address to listen on")
+	pflag.BoolVar(&targetTLS, "tls", targetTLS, "serve the target address over HTTPS using a generated local CA")
+	pflag.StringVar(&targetTLSDir, "tls-dir", targetTLSDir, "directory to store the local CA and certificate in, defaults to the user config directory")
 	pflag.StringVarP(&fileServerAddr, "file-server", "F", fileServerAddr, "file server address to listen on, empty to disable")
+	pflag.StringArrayVar(&serveFlags, "serve", serveFlags, "directory that the file server serves at a path prefix, e.g. /assets=./dist, defaults to /=--include (repeatable)")
+	pflag.StringSliceVar(&fileIndex, "index", fileIndex, "index files that the file server serves for directories, in order of preference")
+	pflag.BoolVar(&fileSPA, "spa", fileSPA, "file server serves the root index file for missing paths without an extension")
+	pflag.BoolVar(&fileCleanURLs, "clean-urls", fileCleanURLs, "file server serves /about.html for /about")
+	pflag.StringVar(&fileNotFound, "not-found", fileNotFound, "file that the file server serves for missing paths, as a path from the URL root like /404.html")
+	pflag.BoolVar(&fileNoListing, "no-listing", fileNoListing, "file server does not list directories without an index file")
+	pflag.BoolVar(&fileMarkdown, "markdown", fileMarkdown, "file server renders Markdown files and READMEs in directory listings as HTML")
 	pflag.StringVar(&gitignoreFile, "gitignore", gitignoreFile, "gitignore file to use, empty to disable")
 	pflag.StringVar(&generateCheckCmd, "generated-check", generateCheckCmd, "command to check if a file is generated, executes $SHELL or /bin/sh otherwise")
+	pflag.StringVar(&controlSocket, "control", controlSocket, "serve the control API for saq ctl on this socket path, auto to derive it from --include")
+	pflag.BoolVar(&noKeys, "no-keys", noKeys, "do not read keys from the terminal, such as r to restart")
+	pflag.BoolVar(&tui, "tui", tui, "show a dashboard with the state of saq and the command's output in the terminal")
 	pflag.BoolVar(&noBrowser, "no-browser", noBrowser, "do not open browser")
 	pflag.BoolVar(&browserOpenOnce, "browser-open-once", browserOpenOnce, "only open browser once, otherwise it will open if there are no active browsers")
-	pflag.BoolVarP(&verbose, "verbose", "v", verbose, "verbose logging")
+	pflag.BoolVarP(&verbose, "verbose", "v", verbose, "verbose logging, same as --log-level=debug")
+	pflag.StringVar(&logLevelName, "log-level", logLevelName, "level of saq's own logs: debug, info, warn or error")
+	pflag.StringVar(&logFormat, "log-format", logFormat, "format of saq's own logs: text or json")
+	pflag.StringVar(&eventsFormat, "events", eventsFormat, "write lifecycle events in this format, only ndjson is supported")
+	pflag.StringVar(&eventsOutput, "events-output", eventsOutput, "file or FIFO to write events to, - for stdout")
+	pflag.StringVar(&cspMode, "csp", cspMode, "how the injected script gets past Content-Security-Policy: auto, nonce, hash or external")
+	pflag.StringSliceVar(&injectInclude, "inject-include", injectInclude, "only inject the script into paths matching these globs (suffix /** matches subpaths)")
+	pflag.StringSliceVar(&injectExclude, "inject-exclude", injectExclude, "never inject the script into paths matching these globs (suffix /** matches subpaths)")
 	pflag.Parse()
 
 	if len(os.Args) < 2 {
@@ -68,34 +187,155 @@ func main() {
 		os.Exit(1)
 	}
 
+	// output is where the command's output goes when the dashboard is shown.
+	var output *outputPane
+	if tui && term.IsTerminal(int(os.Stdout.Fd())) {
+		output = newOutputPane()
+	}
+
+	if verbose {
+		logLevelName = "debug"
+	}
+
+	// saq's own logs go to stderr, separately from the command's output, which
+	// is passed through as is.
+	var logOutput io.Writer = os.Stderr
+	if output != nil {
+		logOutput = output
+	}
+	if err := setupLogging(logOutput, logLevelName, logFormat); err != nil {
+		log.Fatalln(err)
+	}
+
+	if tui && output == nil {
+		slog.Warn("stdout is not a terminal, not showing the dashboard")
+	}
+
+	var events *eventStream
+	switch eventsFormat {
+	case "":
+	case "ndjson":
+		if output != nil && eventsOutput == "-" {
+			fatal("--events cannot write to stdout with --tui, use --events-output")
+		}
+		events = newEventStream(eventsOutput)
+	default:
+		fatal("invalid --events, must be ndjson", "format", eventsFormat)
+	}
+
 	// These are always excluded.
 	excludeDirs = append(excludeDirs, "./.git", "./.direnv")
 
+	var mocks *mock.Server
+	if mockPath != "" {
+		var err error
+		mocks, err = mock.NewServer(mockPath)
+		if err != nil {
+			fatal("invalid --mocks", "err", err)
+		}
+
+		// Editing mocks only reloads the page, so don't restart the runner
+		// for them.
+		excludeDirs = append(excludeDirs, mockExcludes(includeDir, mocks)...)
+	}
+
 	for _, excl := range excludeDirs {
 		if err := checkValidExclude(excl); err != nil {
-			log.Fatalln("invalid --exclude:", err)
+			fatal("invalid --exclude", "err", err)
+		}
+	}
+
+	if inspectSize < 0 {
+		fatal("invalid --inspect, must not be negative", "size", inspectSize)
+	}
+	if inspectBodyMax < 0 {
+		fatal("invalid --inspect-body-max, must not be negative", "max", inspectBodyMax)
+	}
+
+	for _, pattern := range append(injectInclude, injectExclude...) {
+		if err := proxy.CheckPathPattern(pattern); err != nil {
+			fatal("invalid inject pattern", "pattern", pattern, "err", err)
 		}
 	}
 
 	if fileServerAddr != "" && sourceURL != "" {
-		log.Println("warning: --file-server is enabled, --source will be ignored")
+		slog.Warn("--file-server is enabled, --source will be ignored")
 		sourceURL = fileServerAddr
 	}
 
-	if !strings.Contains(sourceURL, "://") {
-		sourceURL = "http://" + sourceURL
+	var mounts []fileserver.Mount
+	for _, flag := range serveFlags {
+		mount, err := fileserver.ParseMount(flag)
+		if err != nil {
+			fatal("invalid --serve", "err", err)
+		}
+		mounts = append(mounts, mount)
+	}
+	if len(mounts) > 0 && fileServerAddr == "" {
+		fatal("--serve requires --file-server")
+	}
+	if len(mounts) == 0 {
+		mounts = []fileserver.Mount{{Prefix: "/", Dir: includeDir}}
+	}
+
+	src, err := parseSourceURL(sourceURL)
+	if err != nil {
+		fatal("invalid --source URL", "err", err)
+	}
+
+	routes, err := parseRoutes(routeFlags)
+	if err != nil {
+		fatal("invalid --route", "err", err)
+	}
+
+	// --source is the fallback route unless it's overridden.
+	hasRoot := false
+	for _, route := range routes {
+		hasRoot = hasRoot || route.patterns()[0] == "/"
+	}
+	if !hasRoot {
+		routes = append(routes, Route{Prefix: "/", Source: src})
+	}
+
+	var netsimRules []netsim.Rule
+	for _, flag := range netsimFlags {
+		rule, err := netsim.ParseRule(flag)
+		if err != nil {
+			fatal("invalid --netsim", "err", err)
+		}
+		netsimRules = append(netsimRules, rule)
+	}
+
+	var headerRules []header.Rule
+	for _, flag := range headerFlags {
+		rule, err := header.ParseRule(flag)
+		if err != nil {
+			fatal("invalid --header", "err", err)
+		}
+		headerRules = append(headerRules, rule)
+	}
+
+	csp, err := proxy.ParseCSPMode(cspMode)
+	if err != nil {
+		fatal("invalid --csp", "err", err)
 	}
 
-	src, err := url.Parse(sourceURL)
+	sourceTransport, err := newSourceTransport(sourceCA, sourceInsecure, sourceCert, sourceKey)
 	if err != nil {
-		log.Fatalln("invalid --source URL:", err)
+		fatal("invalid source TLS options", "err", err)
 	}
 
-	if !verbose {
-		log.SetOutput(io.Discard)
+	var tlsConfig *tls.Config
+	if targetTLS {
+		tlsConfig, err = loadTargetTLS(targetTLSDir, targetAddr)
+		if err != nil {
+			fatal("cannot set up TLS", "err", err)
+		}
 	}
 
 	var browserCount atomicg.Int
+	// connected is the number of browsers waiting for a reload.
+	var connected atomicg.Int
 
 	wg, ctx := errgroup.WithContext(ctx)
 
@@ -104,16 +344,34 @@ func main() {
 		Excludes:          excludeDirs,
 		Gitignore:         gitignoreFile,
 		GeneratedCheckCmd: generateCheckCmd,
+		PauseOnGit:        true,
 	})
 	wg.Go(func() error {
 		return observer.Start(ctx)
 	})
 
+	// reload is published to reload the page without restarting anything.
+	reload := NewPubsub[Reload]()
+
+	if mocks != nil {
+		wg.Go(func() error {
+			return watchMocks(ctx, mocks, reload)
+		})
+	}
+
 	var runner Runner
 	if len(pflag.Args()) == 0 {
 		runner = NewNoopRunner()
 	} else {
 		cmdRunner := NewCommandRunner(pflag.Args())
+		if output != nil {
+			cmdRunner.Stdout = output
+			cmdRunner.Stderr = output
+		}
+		if events != nil && eventsOutput == "-" {
+			// Keep stdout for the events only.
+			cmdRunner.Stdout = os.Stderr
+		}
 		wg.Go(func() error {
 			return cmdRunner.Start(ctx)
 		})
@@ -122,17 +380,100 @@ func main() {
 
 	if fileServerAddr != "" {
 		wg.Go(func() error {
-			fs := http.FileServer(http.Dir(includeDir))
-			log.Println("file server is listening on", fileServerAddr)
+			fs := fileserver.NewServer(mounts, fileserver.Opts{
+				Index:     fileIndex,
+				SPA:       fileSPA,
+				CleanURLs: fileCleanURLs,
+				NotFound:  fileNotFound,
+				NoListing: fileNoListing,
+				Markdown:  fileMarkdown,
+			})
+			slog.Info("file server is listening", "component", "fileserver", "addr", fileServerAddr)
 			return hserve.ListenAndServe(ctx, fileServerAddr, fs)
 		})
 	}
 
-	serverMon := NewHTTPMonitor(sourceURL)
+	sourceClient := &http.Client{Transport: sourceTransport}
+
+	// restarting is set from when the runner is told to restart until the
+	// sources are alive again.
+	var restarting atomicg.Bool
+
+	// Routes with the same source share a monitor.
+	monitors := make(map[string]*HTTPMonitor)
+	var monitorList []*HTTPMonitor
+
+	proxies := make([]*proxy.ReverseProxy, len(routes))
+	for i, route := range routes {
+		source := route.Source.String()
+		if _, ok := monitors[source]; !ok {
+			monitors[source] = NewHTTPMonitor(source, sourceClient)
+			monitorList = append(monitorList, monitors[source])
+		}
+
+		proxies[i] = proxy.NewReverseProxy(*route.Source, proxy.Opts{
+			Transport:     sourceTransport,
+			Script:        hook,
+			CSPMode:       csp,
+			InjectInclude: injectInclude,
+			InjectExclude: injectExclude,
+			Upstream: monitorUpstream{
+				HTTPMonitor: monitors[source],
+				restarting:  &restarting,
+			},
+			HoldTimeout: holdTimeout,
+			Queue: proxy.QueueOpts{
+				MaxRequests: queueMax,
+				MaxBodySize: queueBodyMax,
+				Timeout:     queueTimeout,
+			},
+		})
+	}
+
+	// The page is only reloaded once all sources are alive, since the page
+	// likely depends on all of them.
+	serverMon := NewHTTPMonitorGroup(monitorList...)
 	wg.Go(func() error {
 		return serverMon.Start(ctx)
 	})
 
+	var dash *dashboard
+	if output != nil {
+		dash = &dashboard{
+			runner:   runner,
+			observer: observer,
+			monitor:  serverMon,
+			browsers: &connected,
+			output:   output,
+			command:  pflag.Args(),
+			started:  time.Now(),
+		}
+		wg.Go(func() error {
+			return dash.Start(ctx)
+		})
+	}
+
+	restartRunner := func() {
+		if dash != nil {
+			dash.MarkRestart()
+		}
+		restarting.Set()
+		runner.Restart()
+	}
+
+	if events != nil {
+		wg.Go(func() error {
+			return events.Start(ctx)
+		})
+		wg.Go(func() error {
+			return events.Follow(ctx, observer, runner, serverMon)
+		})
+	}
+
+	// If nothing is run and files are served by us, then there's nothing to
+	// restart or wait for, so changes reload the page right away.
+	fastReload := fileServerAddr != "" && len(pflag.Args()) == 0
+
 	wg.Go(func() error {
 		observeCh := observer.Subscribe()
 		defer observer.Unsubscribe(observeCh)
@@ -140,20 +481,59 @@ func main() {
 		runnerCh := runner.Subscribe()
 		defer runner.Unsubscribe(runnerCh)
 
+		monitorCh := serverMon.Subscribe()
+		defer serverMon.Unsubscribe(monitorCh)
+
+		// restarted is true once the runner has started the command again,
+		// after which the sources coming alive means that they're the new
+		// ones.
+		var restarted bool
+
 		for {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case <-observeCh:
-				log.Println("observer detected changes, restarting runner")
-				runner.Restart()
+			case files := <-observeCh:
+				if fastReload {
+					slog.Info("files changed, reloading page", "files", files)
+					reload.Publish(reloadFor(files))
+					continue
+				}
+				slog.Info("files changed, restarting command", "files", files)
+				restartRunner()
 			case <-runnerCh:
-				log.Println("runner restarted, monitoring server until it's alive")
+				slog.Debug("command restarted, monitoring sources until they're alive")
+				restarted = true
+				// Upgraded connections are still talking to the old process,
+				// so kick them off to make them reconnect.
+				for _, rp := range proxies {
+					rp.CloseUpgraded()
+				}
 				serverMon.RefreshUntilState(ctx, HTTPStateAlive)
+			case state := <-monitorCh:
+				if restarted && state == HTTPStateAlive {
+					restarted = false
+					restarting.Unset()
+				}
 			}
 		}
 	})
 
+	openBrowser := func() {
+		addr := targetAddr
+		if !strings.Contains(addr, "://") {
+			if tlsConfig != nil {
+				addr = "https://" + addr
+			} else {
+				addr = "http://" + addr
+			}
+		}
+
+		if err := browser.OpenURL(addr); err != nil {
+			slog.Warn("cannot open browser", "err", err)
+		}
+	}
+
 	wg.Go(func() error {
 		if noBrowser {
 			return nil
@@ -175,23 +555,82 @@ func main() {
 
 			// Open a browser if there are no other browsers open.
 			if browserCount.Get() == 0 {
-				addr := targetAddr
-				if !strings.Contains(addr, "://") {
-					addr = "http://" + addr
-				}
-
-				if err := browser.OpenURL(addr); err != nil {
-					log.Println("error opening browser:", err)
-				}
+				openBrowser()
 			}
 		}
 	})
 
+	reloadBrowsers := func() {
+		reload.Publish(ReloadPage)
+	}
+
+	if controlSocket == "auto" {
+		socket, err := defaultControlSocket(includeDir)
+		if err != nil {
+			fatal("cannot use the default control socket", "err", err)
+		}
+		controlSocket = socket
+	}
+	if controlSocket != "" {
+		control := &controlServer{
+			runner:   runner,
+			observer: observer,
+			monitor:  serverMon,
+			browsers: &connected,
+			started:  time.Now(),
+			restart:  restartRunner,
+			reload:   reloadBrowsers,
+			stop:     cancel,
+		}
+		wg.Go(func() error {
+			return control.serve(ctx, controlSocket)
+		})
+	}
+
+	restoreTerm := func() {}
+	if !noKeys {
+		// Turning debug logging off goes back to how saq started, unless
+		// that was with debug logging, in which case it goes to the default.
+		quietLevel := logLevel.Level()
+		if quietLevel <= slog.LevelDebug {
+			quietLevel = slog.LevelWarn
+		}
+
+		actions := &keyActions{
+			restart:    restartRunner,
+			reload:     reloadBrowsers,
+			open:       openBrowser,
+			stop:       cancel,
+			observer:   observer,
+			level:      logLevel,
+			quietLevel: quietLevel,
+		}
+		if dash != nil {
+			actions.out = output
+			actions.clear = output.Clear
+			actions.scroll = dash.Scroll
+		}
+		restore, err := listenKeys(ctx, actions)
+		if err != nil {
+			slog.Warn("cannot read keys from the terminal", "err", err)
+		} else {
+			restoreTerm = restore
+		}
+	}
+
 	r := http.NewServeMux()
 	r.HandleFunc("/__refresh", func(w http.ResponseWriter, r *http.Request) {
 		ch := serverMon.Subscribe()
 		defer serverMon.Unsubscribe(ch)
 
+		reloadCh := reload.Subscribe()
+		defer reload.Unsubscribe(reloadCh)
+
+		events.Emit("browser.connect", "browsers", connected.Add(1))
+		defer func() {
+			events.Emit("browser.disconnect", "browsers", connected.Add(-1))
+		}()
+
 		if browserOpenOnce {
 			browserCount.Set(1)
 		} else {
@@ -206,30 +645,114 @@ func main() {
 				return
 			case state := <-ch:
 				if state == HTTPStateAlive {
-					log.Println("server is alive, refreshing page")
+					slog.Debug("sources are alive, reloading page")
+					events.Emit("browser.reload", "reload", ReloadPage.String())
 					w.WriteHeader(http.StatusNoContent)
 					return
 				}
+			case kind := <-reloadCh:
+				events.Emit("browser.reload", "reload", kind.String())
+				if kind == ReloadCSS {
+					w.Header().Set("Content-Type", "text/plain")
+					io.WriteString(w, "css")
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
 			}
 		}
 	})
 
-	r.Handle("/", proxy.NewReverseProxy(*src, func(body []byte) []byte {
-		return append(body, []byte(hook)...)
-	}))
+	recorder := inspect.NewRecorder(inspectSize, inspectBodyMax)
+	if inspectSize > 0 {
+		recorder.Mount(r)
+	}
+
+	var simulator *netsim.Simulator
+	if len(netsimRules) > 0 {
+		simulator = netsim.NewSimulator(netsimRules)
+		r.Handle(netsim.Path, simulator.Handler())
+	}
+
+	// Headers and CORS are only for the routes, never for saq's own endpoints,
+	// which must not be readable from other origins.
+	headers := header.NewRewriter(header.Opts{
+		Rules: headerRules,
+		CORS:  cors,
+	})
+
+	for i, route := range routes {
+		var h http.Handler = proxies[i]
+		if mocks != nil {
+			h = mocks.Wrap(h)
+		}
+		if simulator != nil {
+			h = simulator.Wrap(h)
+		}
+		h = recorder.Wrap(headers.Wrap(h))
+
+		for _, pattern := range route.patterns() {
+			r.Handle(pattern, h)
+		}
+	}
 
 	wg.Go(func() error {
-		log.Println("listening on", targetAddr)
+		slog.Info("listening", "addr", targetAddr)
+		if tlsConfig != nil {
+			return listenAndServeTLS(ctx, targetAddr, r, tlsConfig)
+		}
 		return hserve.ListenAndServe(ctx, targetAddr, r)
 	})
 
-	if err := wg.Wait(); err != nil && !errors.Is(err, context.Canceled) {
-		log.Fatalln("error:", err)
+	err = wg.Wait()
+	restoreTerm()
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fatal("error", "err", err)
+	}
+}
+
+// monitorUpstream tells the proxy about an upstream using its monitor.
+type monitorUpstream struct {
+	*HTTPMonitor
+	restarting *atomicg.Bool
+}
+
+func (u monitorUpstream) Status() proxy.UpstreamStatus {
+	state := u.State()
+	restarting := u.restarting.IsSet()
+	return proxy.UpstreamStatus{
+		// The monitor only notices that the server is gone after the runner
+		// is done restarting it.
+		Alive:      state == HTTPStateAlive && !restarting,
+		State:      state.String(),
+		Restarting: restarting,
+	}
+}
+
+// WaitAlive waits until Status reports the upstream as alive. The monitor is
+// not refreshed, since that would make it publish its state again and reload
+// every browser. The restarting flag isn't published, so it's polled.
+func (u monitorUpstream) WaitAlive(ctx context.Context) error {
+	ch := u.Subscribe()
+	defer u.Unsubscribe(ch)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !u.Status().Alive {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		case <-ticker.C:
+		}
 	}
+	return nil
 }
 
 func assert(cond bool, msg string) {
 	if !cond {
-		log.Fatalln(msg)
+		fatal(msg)
 	}
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"libdb.so/saq/internal/mock"
+)
+
+// mockExcludes returns the excludes that keep changes to the mocks from
+// restarting the command, since they only reload the page. The paths are
+// joined onto includeDir the same way that the observer names changed files.
+func mockExcludes(includeDir string, mocks *mock.Server) []string {
+	path := mocks.Dir()
+	if path == "" {
+		path = mocks.File()
+	}
+
+	absInclude, err1 := filepath.Abs(includeDir)
+	absPath, err2 := filepath.Abs(path)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+
+	rel, err := filepath.Rel(absInclude, absPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+
+	excl := filepath.Join(includeDir, rel)
+	if mocks.Dir() != "" {
+		// Don't exclude files that merely start with the directory's name.
+		excl += string(filepath.Separator)
+	}
+	return []string{"." + string(filepath.Separator) + excl}
+}
+
+// watchMocks reloads the mocks and then the page whenever they change. The
+// mock directory is watched as a whole, while the files outside of it are
+// watched on their own and change with the config.
+func watchMocks(ctx context.Context, mocks *mock.Server, reload *Pubsub[Reload]) error {
+	logger := slog.With("component", "mock")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	dirErr := make(chan error, 1)
+	var dirChanges <-chan []string
+	if dir := mocks.Dir(); dir != "" {
+		observer := NewObserver(Observed{Root: dir})
+		dirChanges = observer.Subscribe()
+		defer observer.Unsubscribe(dirChanges)
+		go func() { dirErr <- observer.Start(ctx) }()
+	}
+
+	var files []string
+	var fileChanges <-chan []string
+	stopFiles := func() {}
+	defer func() { stopFiles() }()
+
+	// watchFiles starts watching the files again if they changed.
+	watchFiles := func() {
+		next := mocks.Files()
+		if slices.Equal(files, next) {
+			return
+		}
+
+		stopFiles()
+		files, fileChanges, stopFiles = next, nil, func() {}
+		if len(files) == 0 {
+			return
+		}
+
+		// Watch the files that exist or may be created, since the watcher
+		// needs their directories.
+		var watched []string
+		for _, file := range files {
+			if stat, err := os.Stat(filepath.Dir(file)); err == nil && stat.IsDir() {
+				watched = append(watched, file)
+			} else {
+				logger.Warn("not watching mock file, its directory is missing", "file", file)
+			}
+		}
+		if len(watched) == 0 {
+			return
+		}
+
+		filesCtx, cancel := context.WithCancel(ctx)
+		observer := NewObserver(Observed{Files: watched})
+		ch := observer.Subscribe()
+		fileChanges = ch
+		stopFiles = func() {
+			cancel()
+			observer.Unsubscribe(ch)
+		}
+
+		go func() {
+			if err := observer.Start(filesCtx); filesCtx.Err() == nil {
+				logger.Warn("cannot watch mock files", "err", err)
+			}
+		}()
+	}
+	watchFiles()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-dirErr:
+			return err
+		case <-dirChanges:
+		case <-fileChanges:
+		}
+
+		logger.Info("mocks changed, reloading page")
+		mocks.Reload()
+		watchFiles()
+		reload.Publish(ReloadPage)
+	}
+}
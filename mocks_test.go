@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"libdb.so/saq/internal/mock"
+)
+
+func TestMockExcludes(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"src/mocks", "other"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"src/mocks.json", "other/mocks.json"} {
+		if err := os.WriteFile(filepath.Join(root, file), []byte("[]"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		includeDir string
+		mocks      string
+		want       []string
+	}{
+		{
+			name:       "directory",
+			includeDir: filepath.Join(root, "src"),
+			mocks:      filepath.Join(root, "src", "mocks"),
+			want:       []string{"./" + filepath.Join(root, "src", "mocks") + "/"},
+		},
+		{
+			name:       "config file",
+			includeDir: filepath.Join(root, "src"),
+			mocks:      filepath.Join(root, "src", "mocks.json"),
+			want:       []string{"./" + filepath.Join(root, "src", "mocks.json")},
+		},
+		{
+			name:       "relative include",
+			includeDir: "./src",
+			mocks:      filepath.Join(root, "src", "mocks"),
+			want:       []string{"./src/mocks/"},
+		},
+		{
+			name:       "outside",
+			includeDir: filepath.Join(root, "src"),
+			mocks:      filepath.Join(root, "other", "mocks.json"),
+		},
+		{
+			name:       "include directory",
+			includeDir: filepath.Join(root, "src", "mocks"),
+			mocks:      filepath.Join(root, "src", "mocks"),
+		},
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mocks, err := mock.NewServer(test.mocks)
+			if err != nil {
+				t.Fatal("cannot create mock server:", err)
+			}
+
+			excludes := mockExcludes(test.includeDir, mocks)
+			if !reflect.DeepEqual(excludes, test.want) {
+				t.Errorf("excludes = %q, want %q", excludes, test.want)
+			}
+			for _, excl := range excludes {
+				if err := checkValidExclude(excl); err != nil {
+					t.Errorf("invalid exclude %q: %v", excl, err)
+				}
+			}
+		})
+	}
+}
@@ -4,15 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/illarion/gonotify/v2"
 	gitignore "github.com/sabhiram/go-gitignore"
+	"libdb.so/saq/internal/atomicg"
 )
 
 // Observed is a set of paths to observe.
@@ -21,25 +23,134 @@ type Observed struct {
 	Excludes          []string
 	Gitignore         string
 	GeneratedCheckCmd string
+	// PauseOnGit pauses the observer while a git operation such as a rebase
+	// is in progress in Root.
+	PauseOnGit bool
+	// Files, if not empty, limits the observer to just these files instead
+	// of everything under Root.
+	Files []string
 }
 
-// Observer observes a set of paths for changes.
+// Observer observes a set of paths for changes. The paths of changed files are
+// published in batches, which usually have a single path unless the observer
+// was paused.
 type Observer struct {
-	Subscriber[struct{}]
+	Subscriber[[]string]
 
 	obs    Observed
-	pubsub *Pubsub[struct{}]
+	pubsub *Pubsub[[]string]
+	resume chan struct{}
+	logger *slog.Logger
 
 	generatedIndex sync.Map // map[string]bool
+	paused         atomicg.Bool
+	gitBusy        atomicg.Bool
+	gitDir         string
 }
 
+// observerBuffer is the number of batches of changes that a subscriber can
+// fall behind by, such as while the command is restarting.
+const observerBuffer = 16
+
 // NewObserver creates a new observer for the given paths.
 func NewObserver(observed Observed) *Observer {
-	pubsub := NewPubsub[struct{}]()
+	pubsub := NewBufferedPubsub[[]string](observerBuffer)
 	return &Observer{
 		Subscriber: pubsub,
 		obs:        observed,
 		pubsub:     pubsub,
+		resume:     make(chan struct{}, 1),
+		logger:     slog.With("component", "observer", "root", observed.Root),
+	}
+}
+
+// Pause buffers changes until Resume is called, which then publishes all of
+// them as a single batch.
+func (o *Observer) Pause() {
+	o.paused.Set()
+}
+
+// Resume resumes publishing changes.
+func (o *Observer) Resume() {
+	o.paused.Unset()
+
+	select {
+	case o.resume <- struct{}{}:
+	default:
+	}
+}
+
+// Paused returns true if the observer is paused, either by Pause or because a
+// git operation is in progress.
+func (o *Observer) Paused() bool {
+	return o.paused.IsSet() || o.gitBusy.IsSet()
+}
+
+// gitCheckInterval is how often the git directory is checked for operations in
+// progress.
+const gitCheckInterval = 500 * time.Millisecond
+
+// gitBusyFiles are the files in the git directory that exist while git is in
+// the middle of something that touches the work tree.
+var gitBusyFiles = []string{
+	"rebase-merge",
+	"rebase-apply",
+	"index.lock",
+}
+
+// findGitDir returns the git directory of the work tree at root, or an empty
+// string if there is none. In linked worktrees and submodules, .git is a file
+// that points to the actual git directory.
+func findGitDir(root string) string {
+	dotGit := filepath.Join(root, ".git")
+
+	stat, err := os.Stat(dotGit)
+	if err != nil {
+		return ""
+	}
+	if stat.IsDir() {
+		return dotGit
+	}
+
+	b, err := os.ReadFile(dotGit)
+	if err != nil {
+		return ""
+	}
+
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(b)), "gitdir:")
+	if !ok {
+		return ""
+	}
+
+	dir = strings.TrimSpace(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir
+}
+
+// checkGit updates whether a git operation is in progress.
+func (o *Observer) checkGit() {
+	if o.gitDir == "" {
+		return
+	}
+
+	busy := false
+	for _, name := range gitBusyFiles {
+		if _, err := os.Stat(filepath.Join(o.gitDir, name)); err == nil {
+			busy = true
+			break
+		}
+	}
+
+	if busy != o.gitBusy.IsSet() {
+		if busy {
+			o.logger.Info("git operation in progress, pausing")
+			o.gitBusy.Set()
+		} else {
+			o.logger.Info("git operation done, resuming")
+			o.gitBusy.Unset()
+		}
 	}
 }
 
@@ -59,9 +170,45 @@ func (o *Observer) Start(ctx context.Context) error {
 		}
 	}
 
-	watcher, err := gonotify.NewDirWatcher(ctx, wmask, o.obs.Root)
-	if err != nil {
-		return err
+	var events chan gonotify.FileEvent
+	if len(o.obs.Files) > 0 {
+		watcher, err := gonotify.NewFileWatcher(ctx, wmask, o.obs.Files...)
+		if err != nil {
+			return err
+		}
+		events = watcher.C
+	} else {
+		watcher, err := gonotify.NewDirWatcher(ctx, wmask, o.obs.Root)
+		if err != nil {
+			return err
+		}
+		events = watcher.C
+	}
+
+	if o.obs.PauseOnGit {
+		o.gitDir = findGitDir(o.obs.Root)
+	}
+
+	ticker := time.NewTicker(gitCheckInterval)
+	defer ticker.Stop()
+
+	// pending are the changes made while paused.
+	var pending []string
+	pendingSet := make(map[string]bool)
+
+	flush := func() {
+		if len(pending) == 0 || o.Paused() {
+			return
+		}
+		// Keep the changes around until everyone got them, or the command
+		// may never see them.
+		if !o.pubsub.Publish(pending) {
+			o.logger.Debug("subscribers are falling behind, retrying changed files", "count", len(pending))
+			return
+		}
+		o.logger.Info("resumed, published changed files", "count", len(pending))
+		pending = nil
+		pendingSet = make(map[string]bool)
 	}
 
 eventLoop:
@@ -70,12 +217,19 @@ eventLoop:
 		case <-ctx.Done():
 			return ctx.Err()
 
-		case ev := <-watcher.C:
+		case <-o.resume:
+			flush()
+
+		case <-ticker.C:
+			o.checkGit()
+			flush()
+
+		case ev := <-events:
 			if ev.Eof {
 				return fmt.Errorf("watcher closed")
 			}
 
-			log.Println("file reloaded:", ev)
+			o.logger.Debug("file changed", "path", ev.Name, "mask", gonotify.InMaskToString(ev.Mask))
 
 			if ignore != nil && ignore.MatchesPath(ev.Name) {
 				continue
@@ -84,7 +238,7 @@ eventLoop:
 			for _, excl := range o.obs.Excludes {
 				if first, rest := popFirstPart(excl); first == "." {
 					if strings.HasPrefix(ev.Name, rest) {
-						log.Printf("excluded %q on rule %q", ev, excl)
+						o.logger.Debug("excluded file", "path", ev.Name, "rule", excl)
 						continue eventLoop
 					}
 					continue
@@ -92,7 +246,7 @@ eventLoop:
 
 				match, _ := filepath.Match(excl, ev.Name)
 				if match {
-					// log.Printf("excluded %q on rule %q", ev, excl)
+					// o.logger.Debug("excluded file", "path", ev.Name, "rule", excl)
 					continue eventLoop
 				}
 			}
@@ -101,22 +255,32 @@ eventLoop:
 			if v, ok := o.generatedIndex.Load(ev.Name); ok {
 				generated = v.(bool)
 				if !generated {
-					log.Printf("included %q because it is not generated (cached)", ev.Name)
+					o.logger.Debug("included file because it is not generated", "path", ev.Name, "cached", true)
 				}
 			} else {
 				generated = o.fileIsGenerated(ctx, ev.Name)
 				o.generatedIndex.Store(ev.Name, generated)
 				if !generated {
-					log.Printf("included %q because it is not generated", ev.Name)
+					o.logger.Debug("included file because it is not generated", "path", ev.Name, "cached", false)
 				}
 			}
 
 			if generated {
-				log.Printf("excluded %q because it is generated", ev)
+				o.logger.Debug("excluded file because it is generated", "path", ev.Name)
+				continue
+			}
+
+			o.checkGit()
+			if o.Paused() {
+				if !pendingSet[ev.Name] {
+					pendingSet[ev.Name] = true
+					pending = append(pending, ev.Name)
+				}
+				o.logger.Debug("buffered file because watching is paused", "path", ev.Name)
 				continue
 			}
 
-			o.pubsub.Publish(struct{}{})
+			o.pubsub.Publish([]string{ev.Name})
 		}
 	}
 }
@@ -139,13 +303,11 @@ func (o *Observer) fileIsGenerated(ctx context.Context, path string) bool {
 	if err := cmd.Run(); err != nil {
 		var exitErr *exec.ExitError
 		if !errors.As(err, &exitErr) {
-			log.Printf("cannot run generated check command: %v", err)
+			o.logger.Warn("cannot run generated check command", "err", err)
 			return false
 		}
-		log.Printf("generated check command exited with code %d, treating as not generated", exitErr.ExitCode())
-		if len(exitErr.Stderr) != 0 {
-			log.Printf("command stderr:\n%s", exitErr.Stderr)
-		}
+		o.logger.Debug("generated check command failed, treating as not generated",
+			"path", path, "code", exitErr.ExitCode(), "stderr", string(exitErr.Stderr))
 		return false
 	}
 
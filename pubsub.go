@@ -11,7 +11,8 @@ type Subscriber[T any] interface {
 
 // Pubsub is a pubsub.
 type Pubsub[T any] struct {
-	subs sync.Map // map[<-chan T]chan T
+	subs   sync.Map // map[<-chan T]chan T
+	buffer int
 }
 
 // NewPubsub creates a new pubsub.
@@ -21,9 +22,19 @@ func NewPubsub[T any]() *Pubsub[T] {
 	}
 }
 
+// NewBufferedPubsub creates a new pubsub whose subscribers can fall behind by
+// up to size values before values are dropped for them. Use it when every
+// value matters, not just the latest one.
+func NewBufferedPubsub[T any](size int) *Pubsub[T] {
+	return &Pubsub[T]{
+		subs:   sync.Map{},
+		buffer: size,
+	}
+}
+
 // Subscribe subscribes to the pubsub.
 func (p *Pubsub[T]) Subscribe() <-chan T {
-	ch := make(chan T)
+	ch := make(chan T, p.buffer)
 	p.subs.Store((<-chan T)(ch), ch)
 	return ch
 }
@@ -33,14 +44,18 @@ func (p *Pubsub[T]) Unsubscribe(ch <-chan T) {
 	p.subs.Delete((<-chan T)(ch))
 }
 
-// Publish publishes to the pubsub.
-func (p *Pubsub[T]) Publish(value T) {
+// Publish publishes to the pubsub. It returns false if the value was dropped
+// for any subscriber that is falling behind.
+func (p *Pubsub[T]) Publish(value T) bool {
+	ok := true
 	p.subs.Range(func(k, v interface{}) bool {
 		ch := v.(chan T)
 		select {
 		case ch <- value:
 		default:
+			ok = false
 		}
 		return true
 	})
+	return ok
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Route maps requests with a path prefix to a source server.
+type Route struct {
+	Prefix string
+	Source *url.URL
+}
+
+// parseRoute parses a route in the form of "/prefix=http://host:port".
+func parseRoute(s string) (Route, error) {
+	prefix, source, ok := strings.Cut(s, "=")
+	if !ok {
+		return Route{}, fmt.Errorf("route %q is missing =", s)
+	}
+
+	if !strings.HasPrefix(prefix, "/") {
+		return Route{}, fmt.Errorf("route prefix %q must start with /", prefix)
+	}
+
+	u, err := parseSourceURL(source)
+	if err != nil {
+		return Route{}, fmt.Errorf("route %q: %w", s, err)
+	}
+
+	return Route{
+		Prefix: prefix,
+		Source: u,
+	}, nil
+}
+
+// parseRoutes parses all the given routes. Prefixes that only differ by a
+// trailing slash are the same route, so they're rejected as duplicates.
+func parseRoutes(flags []string) ([]Route, error) {
+	routes := make([]Route, 0, len(flags))
+	seen := make(map[string]string, len(flags))
+
+	for _, flag := range flags {
+		route, err := parseRoute(flag)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := route.patterns()[0]
+		if dupe, ok := seen[prefix]; ok {
+			return nil, fmt.Errorf("route %q has the same prefix as %q", flag, dupe)
+		}
+		seen[prefix] = flag
+
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+func parseSourceURL(source string) (*url.URL, error) {
+	if !strings.Contains(source, "://") {
+		source = "http://" + source
+	}
+	return url.Parse(source)
+}
+
+// patterns returns the http.ServeMux patterns that the route should be
+// registered with. Both the prefix itself and everything under it are
+// matched, without ServeMux redirecting one to the other.
+func (r Route) patterns() []string {
+	prefix := strings.TrimSuffix(r.Prefix, "/")
+	if prefix == "" {
+		return []string{"/"}
+	}
+	return []string{prefix, prefix + "/"}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		in      string
+		prefix  string
+		source  string
+		wantErr bool
+	}{
+		{in: "/api=http://localhost:8081", prefix: "/api", source: "http://localhost:8081"},
+		{in: "/api/=localhost:8081", prefix: "/api/", source: "http://localhost:8081"},
+		{in: "/=https://example.com/base", prefix: "/", source: "https://example.com/base"},
+		{in: "/a=b=http://localhost", wantErr: true},
+		{in: "/api", wantErr: true},
+		{in: "api=http://localhost:8081", wantErr: true},
+		{in: "/api=http://[::1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			route, err := parseRoute(test.in)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", route)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if route.Prefix != test.prefix {
+				t.Errorf("prefix = %q, want %q", route.Prefix, test.prefix)
+			}
+			if route.Source.String() != test.source {
+				t.Errorf("source = %q, want %q", route.Source, test.source)
+			}
+		})
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		wantErr bool
+	}{
+		{name: "distinct", in: []string{"/api=:8081", "/app=:8082", "/=:8083"}},
+		{name: "nested", in: []string{"/api=:8081", "/api/v2=:8082"}},
+		{name: "duplicate", in: []string{"/api=:8081", "/api=:8082"}, wantErr: true},
+		{name: "trailing slash", in: []string{"/api=:8081", "/api/=:8082"}, wantErr: true},
+		{name: "root", in: []string{"/=:8081", "//=:8082"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, err := parseRoutes(test.in)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", routes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if len(routes) != len(test.in) {
+				t.Fatalf("got %d routes, want %d", len(routes), len(test.in))
+			}
+		})
+	}
+}
+
+func TestRoutePatterns(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"/", []string{"/"}},
+		{"/api", []string{"/api", "/api/"}},
+		{"/api/", []string{"/api", "/api/"}},
+	}
+
+	for _, test := range tests {
+		got := Route{Prefix: test.prefix}.patterns()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: patterns = %q, want %q", test.prefix, got, test.want)
+		}
+	}
+}
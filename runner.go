@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -17,13 +20,41 @@ type Runner interface {
 	// After the runner has restarted the command, a signal should be emitted to
 	// the Subscriber instance.
 	Restart()
+	// Status returns the status of the command.
+	Status() RunnerStatus
+	// Processes returns a Subscriber that is published to whenever the
+	// command starts or exits.
+	Processes() Subscriber[ProcessEvent]
+}
+
+// ProcessEvent is published by a runner when its command starts or exits.
+type ProcessEvent struct {
+	PID int
+	// Exited is true if the process exited, in which case ExitCode is its exit
+	// code. The exit code is -1 if it was killed by a signal.
+	Exited   bool
+	ExitCode int
+}
+
+// RunnerStatus is the status of a runner's command.
+type RunnerStatus struct {
+	// PID is the process ID of the command, or 0 if it's not running.
+	PID int `json:"pid"`
+	// StartedAt is when the command was last started.
+	StartedAt time.Time `json:"startedAt"`
+	// ExitCode is the exit code of the last command that exited, or nil if
+	// none has. It is -1 if the command was killed by a signal.
+	ExitCode *int `json:"exitCode"`
+	// Starts is the number of times the command has been started.
+	Starts int `json:"starts"`
 }
 
 // NoopRunner is a no-op runner. It doesn't run any command but can fully
 // emulate the behavior of a forever-blocking command.
 type NoopRunner struct {
 	Subscriber[struct{}]
-	pubsub *Pubsub[struct{}]
+	pubsub    *Pubsub[struct{}]
+	processes *Pubsub[ProcessEvent]
 }
 
 // NewNoopRunner creates a new no-op runner.
@@ -32,6 +63,7 @@ func NewNoopRunner() *NoopRunner {
 	return &NoopRunner{
 		Subscriber: pubsub,
 		pubsub:     pubsub,
+		processes:  NewBufferedPubsub[ProcessEvent](8),
 	}
 }
 
@@ -40,14 +72,36 @@ func (r *NoopRunner) Restart() {
 	go r.pubsub.Publish(struct{}{})
 }
 
+// Status returns an empty status, since there is no command.
+func (r *NoopRunner) Status() RunnerStatus {
+	return RunnerStatus{}
+}
+
+// Processes returns a Subscriber that is never published to, since there is
+// no command.
+func (r *NoopRunner) Processes() Subscriber[ProcessEvent] {
+	return r.processes
+}
+
 // CommandRunner is a command runner. It maintains a running command in the
 // background.
 type CommandRunner struct {
 	Subscriber[struct{}]
 
+	// Stdout and Stderr are where the command's output goes. They default to
+	// os.Stdout and os.Stderr.
+	Stdout io.Writer
+	Stderr io.Writer
+
 	args    []string
 	restart chan struct{}
 	pubsub  *Pubsub[struct{}]
+	logger  *slog.Logger
+
+	processes *Pubsub[ProcessEvent]
+
+	statusMu sync.Mutex
+	status   RunnerStatus
 }
 
 // NewCommandRunner creates a new command runner.
@@ -57,19 +111,23 @@ func NewCommandRunner(args []string) *CommandRunner {
 
 	pubsub := NewPubsub[struct{}]()
 	return &CommandRunner{
-		pubsub,
-		args,
-		restart,
-		pubsub,
+		Subscriber: pubsub,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		args:       args,
+		restart:    restart,
+		pubsub:     pubsub,
+		logger:     slog.With("component", "runner"),
+		processes:  NewBufferedPubsub[ProcessEvent](8),
 	}
 }
 
 // Start starts the command runner until the context is canceled.
 func (s *CommandRunner) Start(ctx context.Context) error {
-	var cmd *exec.Cmd
+	var proc *process
 	defer func() {
-		if cmd != nil {
-			stopCommand(cmd)
+		if proc != nil {
+			proc.stop()
 		}
 	}()
 
@@ -80,24 +138,34 @@ func (s *CommandRunner) Start(ctx context.Context) error {
 		case <-s.restart:
 		}
 
-		log.Println("command runner received restart")
+		s.logger.Debug("received restart")
 
-		if cmd != nil {
-			stopCommand(cmd)
-			cmd = nil
+		if proc != nil {
+			proc.stop()
+			proc = nil
 		}
 
-		log.Printf("starting command %q", s.args)
+		s.logger.Info("starting command", "args", s.args)
 
-		cmd = exec.Command(s.args[0], s.args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd := exec.Command(s.args[0], s.args[1:]...)
+		cmd.Stdout = s.Stdout
+		cmd.Stderr = s.Stderr
 		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 		if err := cmd.Start(); err != nil {
 			return fmt.Errorf("failed to start process: %w", err)
 		}
 
+		s.statusMu.Lock()
+		s.status.PID = cmd.Process.Pid
+		s.status.StartedAt = time.Now()
+		s.status.Starts++
+		s.statusMu.Unlock()
+
+		s.logger.Debug("command started", "pid", cmd.Process.Pid)
+		s.processes.Publish(ProcessEvent{PID: cmd.Process.Pid})
+		proc = s.wait(cmd)
+
 		// sleep for a bit to wait for the process to start
 		if err := sleep(ctx, 500*time.Millisecond); err != nil {
 			return err
@@ -121,20 +189,75 @@ func (s *CommandRunner) Restart() {
 	}
 }
 
-func stopCommand(cmd *exec.Cmd) {
-	if cmd == nil {
-		return
+// Status returns the status of the command.
+func (s *CommandRunner) Status() RunnerStatus {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	return s.status
+}
+
+// Processes returns a Subscriber that is published to whenever the command
+// starts or exits.
+func (s *CommandRunner) Processes() Subscriber[ProcessEvent] {
+	return s.processes
+}
+
+// process is a started command that is being waited on.
+type process struct {
+	cmd    *exec.Cmd
+	wait   chan error
+	logger *slog.Logger
+}
+
+// wait waits for the command in the background, recording its exit code once
+// it exits.
+func (s *CommandRunner) wait(cmd *exec.Cmd) *process {
+	proc := &process{
+		cmd:    cmd,
+		wait:   make(chan error, 1),
+		logger: s.logger.With("pid", cmd.Process.Pid),
 	}
 
-	wait := make(chan error, 1)
-	go func() { wait <- cmd.Wait() }()
+	go func() {
+		err := cmd.Wait()
+
+		code := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		proc.logger.Info("command exited", "code", code)
+
+		s.statusMu.Lock()
+		if s.status.PID == cmd.Process.Pid {
+			s.status.PID = 0
+		}
+		s.status.ExitCode = &code
+		s.statusMu.Unlock()
+
+		s.processes.Publish(ProcessEvent{
+			PID:      cmd.Process.Pid,
+			Exited:   true,
+			ExitCode: code,
+		})
+
+		proc.wait <- err
+	}()
+
+	return proc
+}
+
+func (p *process) stop() {
+	cmd := p.cmd
+	wait := p.wait
 
 	select {
 	case <-wait:
 		return
 	default:
 		syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
-		log.Println("sent SIGINT, waiting 2s")
+		p.logger.Debug("sent SIGINT, waiting 2s")
 	}
 
 	timer := time.NewTimer(2 * time.Second)
@@ -142,18 +265,13 @@ func stopCommand(cmd *exec.Cmd) {
 
 	select {
 	case <-timer.C:
-		log.Println("timeout waiting for process to exit, killing...")
+		p.logger.Warn("command did not exit in time, killing it")
 		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
 	case <-wait:
-		log.Println("process exited")
 		return
 	}
 
-	if err := <-wait; err != nil {
-		log.Println("error waiting for process to exit:", err)
-	} else {
-		log.Println("process exited")
-	}
+	<-wait
 }
 
 func sleep(ctx context.Context, d time.Duration) error {
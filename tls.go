@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"libdb.so/hserve"
+	"libdb.so/saq/internal/devcert"
+)
+
+// loadTargetTLS loads the TLS configuration for serving the given address,
+// generating the local CA and certificate as needed.
+func loadTargetTLS(dir, addr string) (*tls.Config, error) {
+	if dir == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("cannot find config directory, use --tls-dir: %w", err)
+		}
+		dir = filepath.Join(configDir, "saq", "tls")
+	}
+
+	ca, err := devcert.LoadAuthority(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if ca.Created {
+		printTrustInstructions(ca.CertFile)
+	}
+
+	cert, err := ca.LeafCertificate(certHosts(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// certHosts returns the hosts that the certificate for addr should be valid
+// for. Localhost and the LAN addresses are always included so that the same
+// certificate works for other devices on the network.
+func certHosts(addr string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil && host != "" && host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			hosts = append(hosts, host)
+		}
+	}
+
+	for _, ip := range devcert.LANAddrs() {
+		hosts = append(hosts, ip.String())
+	}
+
+	return hosts
+}
+
+func printTrustInstructions(caFile string) {
+	fmt.Fprintf(os.Stderr, `saq: generated a local certificate authority at %[1]s
+saq: to make browsers trust it, run one of the following:
+    sudo trust anchor --store %[1]s
+    sudo cp %[1]s /usr/local/share/ca-certificates/saq.crt && sudo update-ca-certificates
+    certutil -d sql:$HOME/.pki/nssdb -A -t C,, -n saq -i %[1]s
+`, caFile)
+}
+
+// listenAndServeTLS is like hserve.ListenAndServe, except it serves HTTPS. The
+// server speaks HTTP/2 if the client supports it.
+func listenAndServeTLS(ctx context.Context, addr string, handler http.Handler, config *tls.Config) error {
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: config,
+	}
+
+	l, err := hserve.Listen(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.ServeTLS(l, "", "") }()
+
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		if err != http.ErrServerClosed {
+			return err
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), hserve.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shutdown gracefully: %w", err)
+	}
+
+	return nil
+}
+
+// newSourceTransport creates the transport used for talking to the source
+// server.
+func newSourceTransport(caFile string, insecure bool, certFile, keyFile string) (*http.Transport, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both the client certificate and key must be given")
+		}
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport, nil
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+	"libdb.so/saq/internal/atomicg"
+)
+
+// outputMaxLines is the number of lines of output that are kept.
+const outputMaxLines = 2000
+
+// outputPane collects the command's output for the dashboard. It is created
+// before the dashboard, so that the command's output can be sent to it from
+// the start.
+type outputPane struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+	scroll  int // lines from the bottom, 0 to follow the output
+	dirty   chan struct{}
+}
+
+func newOutputPane() *outputPane {
+	return &outputPane{dirty: make(chan struct{}, 1)}
+}
+
+// Write adds output to the pane.
+func (o *outputPane) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	data := append(o.partial, b...)
+	for {
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			break
+		}
+		if data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			o.addLine(string(data[:i]))
+			data = data[i+2:]
+			continue
+		}
+		if data[i] == '\r' {
+			// A carriage return overwrites the line, like progress bars do.
+			data = data[i+1:]
+			continue
+		}
+		o.addLine(string(data[:i]))
+		data = data[i+1:]
+	}
+	o.partial = append([]byte(nil), data...)
+
+	o.markDirty()
+	return len(b), nil
+}
+
+func (o *outputPane) addLine(line string) {
+	o.lines = append(o.lines, cleanLine(line))
+	if len(o.lines) > outputMaxLines {
+		o.lines = append(o.lines[:0], o.lines[len(o.lines)-outputMaxLines:]...)
+	}
+	if o.scroll > 0 {
+		// Keep the view still while scrolled up.
+		o.scroll++
+	}
+	if o.scroll > len(o.lines) {
+		o.scroll = len(o.lines)
+	}
+}
+
+// Clear clears the pane.
+func (o *outputPane) Clear() {
+	o.mu.Lock()
+	o.lines = nil
+	o.scroll = 0
+	o.mu.Unlock()
+	o.markDirty()
+}
+
+// scrollBy scrolls up by n lines, or down if n is negative.
+func (o *outputPane) scrollBy(n int) {
+	o.mu.Lock()
+	o.scroll += n
+	if o.scroll > len(o.lines) {
+		o.scroll = len(o.lines)
+	}
+	if o.scroll < 0 {
+		o.scroll = 0
+	}
+	o.mu.Unlock()
+	o.markDirty()
+}
+
+// view returns the last height lines that are scrolled to.
+func (o *outputPane) view(height int) (lines []string, scroll int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	end := len(o.lines) - o.scroll
+	start := end - height
+	if start < 0 {
+		start = 0
+	}
+	return append([]string(nil), o.lines[start:end]...), o.scroll
+}
+
+// tail returns the last n lines.
+func (o *outputPane) tail(n int) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if n > len(o.lines) {
+		n = len(o.lines)
+	}
+	return append([]string(nil), o.lines[len(o.lines)-n:]...)
+}
+
+func (o *outputPane) markDirty() {
+	select {
+	case o.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// dashboard is a terminal UI showing what saq is doing: the command, the state
+// of the sources, the last changed files and the command's output.
+type dashboard struct {
+	runner   Runner
+	observer *Observer
+	monitor  *HTTPMonitorGroup
+	browsers *atomicg.Int
+	output   *outputPane
+	command  []string
+	started  time.Time
+
+	mu        sync.Mutex
+	changed   []string
+	changedAt time.Time
+	restartAt time.Time // zero unless a restart is in progress
+	buildTime time.Duration
+	history   []stateChange
+}
+
+type stateChange struct {
+	state HTTPState
+	at    time.Time
+}
+
+// Scroll scrolls the output up by the given number of lines or pages.
+// Negative numbers scroll down.
+func (d *dashboard) Scroll(n int, page bool) {
+	if page {
+		_, height := d.size()
+		n *= d.paneHeight(height)
+	}
+	d.output.scrollBy(n)
+}
+
+// MarkRestart records that a restart was asked for, so that the time until
+// the sources are alive again can be shown.
+func (d *dashboard) MarkRestart() {
+	d.mu.Lock()
+	if d.restartAt.IsZero() {
+		d.restartAt = time.Now()
+	}
+	d.mu.Unlock()
+	d.output.markDirty()
+}
+
+// Start shows the dashboard until ctx is done. Once done, the last lines of
+// output are printed so that they aren't lost.
+func (d *dashboard) Start(ctx context.Context) error {
+	// Enter the alternate screen and hide the cursor.
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+
+		_, height := d.size()
+		for _, line := range d.output.tail(height) {
+			fmt.Fprintln(os.Stdout, line)
+		}
+	}()
+
+	observeCh := d.observer.Subscribe()
+	defer d.observer.Unsubscribe(observeCh)
+
+	monitorCh := d.monitor.Subscribe()
+	defer d.monitor.Unsubscribe(monitorCh)
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// Redraws for output are throttled so that a flood of output doesn't hog
+	// the terminal. The loop must not block, since states are published
+	// without waiting for subscribers.
+	const frameInterval = 50 * time.Millisecond
+	frame := time.NewTimer(frameInterval)
+	defer frame.Stop()
+	pending := false
+
+	d.draw()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case files := <-observeCh:
+			d.mu.Lock()
+			d.changed = files
+			d.changedAt = time.Now()
+			d.mu.Unlock()
+
+		case state := <-monitorCh:
+			d.mu.Lock()
+			d.history = append(d.history, stateChange{state, time.Now()})
+			if len(d.history) > 5 {
+				d.history = d.history[len(d.history)-5:]
+			}
+			if state == HTTPStateAlive && !d.restartAt.IsZero() {
+				d.buildTime = time.Since(d.restartAt)
+				d.restartAt = time.Time{}
+			}
+			d.mu.Unlock()
+
+		case <-d.output.dirty:
+			pending = true
+			continue
+
+		case <-frame.C:
+			frame.Reset(frameInterval)
+			if !pending {
+				continue
+			}
+
+		case <-winch:
+		case <-ticker.C:
+		}
+
+		d.draw()
+		pending = false
+	}
+}
+
+func (d *dashboard) size() (width, height int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// dashboardHeader is the number of lines above the output pane, and
+// dashboardFooter is the number below it, including the separators.
+const (
+	dashboardHeader = 6
+	dashboardFooter = 2
+)
+
+func (d *dashboard) paneHeight(height int) int {
+	if h := height - dashboardHeader - dashboardFooter; h > 1 {
+		return h
+	}
+	return 1
+}
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+func colorState(state HTTPState) string {
+	switch state {
+	case HTTPStateAlive:
+		return ansiGreen + state.String() + ansiReset
+	case HTTPStateDead:
+		return ansiRed + state.String() + ansiReset
+	default:
+		return ansiYellow + state.String() + ansiReset
+	}
+}
+
+func (d *dashboard) draw() {
+	width, height := d.size()
+	status := d.runner.Status()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out strings.Builder
+	out.WriteString("\x1b[H")
+
+	line := func(s string) {
+		out.WriteString(truncateANSI(s, width))
+		out.WriteString(ansiReset + "\x1b[K\r\n")
+	}
+	label := func(s string) string {
+		return ansiBold + fmt.Sprintf("%-9s", s) + ansiReset
+	}
+
+	// Command and runner state.
+	title := ansiBold + "saq" + ansiReset + "  up " + time.Since(d.started).Round(time.Second).String()
+	if d.observer.Paused() {
+		title += "  " + ansiYellow + "[watching paused]" + ansiReset
+	}
+	line(title)
+
+	var runner string
+	switch {
+	case len(d.command) == 0:
+		runner = ansiDim + "no command" + ansiReset
+	case status.PID != 0:
+		runner = fmt.Sprintf("%s  %spid %d, up %s%s",
+			strings.Join(d.command, " "), ansiGreen, status.PID,
+			time.Since(status.StartedAt).Round(time.Second), ansiReset)
+	default:
+		runner = fmt.Sprintf("%s  %snot running%s", strings.Join(d.command, " "), ansiRed, ansiReset)
+	}
+	if status.ExitCode != nil {
+		runner += fmt.Sprintf("  last exit %d", *status.ExitCode)
+	}
+	line(label("command") + runner)
+
+	// Source state and its history.
+	monitor := colorState(d.monitor.State())
+	if len(d.history) > 1 {
+		var parts []string
+		for _, change := range d.history {
+			parts = append(parts, change.at.Format("15:04:05")+" "+change.state.String())
+		}
+		monitor += ansiDim + "  (" + strings.Join(parts, " → ") + ")" + ansiReset
+	}
+	line(label("source") + monitor)
+
+	// Build time.
+	build := ansiDim + "-" + ansiReset
+	switch {
+	case !d.restartAt.IsZero():
+		build = ansiYellow + "restarting for " + time.Since(d.restartAt).Round(100*time.Millisecond).String() + ansiReset
+	case d.buildTime > 0:
+		build = "alive " + d.buildTime.Round(10*time.Millisecond).String() + " after restart"
+	}
+	line(label("build") + build + "   " + label("browsers") + fmt.Sprint(d.browsers.Get()))
+
+	// Last changed files.
+	changed := ansiDim + "-" + ansiReset
+	if len(d.changed) > 0 {
+		files := d.changed
+		more := ""
+		if len(files) > 5 {
+			more = fmt.Sprintf(" (+%d more)", len(files)-5)
+			files = files[:5]
+		}
+		changed = d.changedAt.Format("15:04:05") + "  " + strings.Join(files, ", ") + more
+	}
+	line(label("changed") + changed)
+
+	line(ansiDim + strings.Repeat("─", width) + ansiReset)
+
+	// Output pane.
+	pane := d.paneHeight(height)
+	shown, scroll := d.output.view(pane)
+	for _, l := range shown {
+		line(l)
+	}
+	for i := len(shown); i < pane; i++ {
+		line("")
+	}
+
+	line(ansiDim + strings.Repeat("─", width) + ansiReset)
+
+	footer := "r restart  b reload  o open  p pause  c clear  ↑↓ PgUp PgDn scroll  q quit"
+	if scroll > 0 {
+		footer = fmt.Sprintf("%s[scrolled up %d lines]%s  %s", ansiYellow, scroll, ansiReset, footer)
+	}
+	// No newline after the last line, or the screen would scroll.
+	out.WriteString(ansiDim + truncateANSI(footer, width) + ansiReset + "\x1b[K\x1b[J")
+
+	os.Stdout.WriteString(out.String())
+}
+
+// cleanLine removes escape sequences and control characters from the line, so
+// that it doesn't mess up the dashboard.
+func cleanLine(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == 0x1b:
+			// Skip CSI sequences, which end with a byte in 0x40-0x7e.
+			if i+1 < len(s) && s[i+1] == '[' {
+				i += 2
+				for i < len(s) && (s[i] < 0x40 || s[i] > 0x7e) {
+					i++
+				}
+			}
+		case c == '\t':
+			b.WriteString("    ")
+		case c < 0x20 || c == 0x7f:
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// truncateANSI truncates s to width visible characters, keeping the escape
+// sequences in it.
+func truncateANSI(s string, width int) string {
+	var b strings.Builder
+	visible := 0
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			j := i + 1
+			if j < len(s) && s[j] == '[' {
+				j++
+				for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+					j++
+				}
+				j++
+			}
+			if j > len(s) {
+				j = len(s)
+			}
+			b.WriteString(s[i:j])
+			i = j
+			continue
+		}
+
+		if visible >= width {
+			i++
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(s[i : i+size])
+		visible++
+		i += size
+	}
+	return b.String()
+}